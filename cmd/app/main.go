@@ -1,28 +1,75 @@
 package main
 
 import (
-	"fmt"
-	"gameslabor/internal/ai"
+	"context"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"gameslabor/internal/games/scenarios"
+	"gameslabor/internal/logging"
+	"gameslabor/internal/prompts"
 	"gameslabor/internal/server"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	defer ai.Cleanup()
+	cfg, _, err := config.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("error while loading configuration", "err", err)
+		os.Exit(2)
+	}
+	if err := cfg.RequireGoogleAPIKey(); err != nil {
+		slog.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
+
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		slog.Error("invalid logging configuration", "err", err)
+		os.Exit(1)
+	}
+
+	s := server.NewServer(cfg)
+
+	if err := scenarios.LoadDir(cfg.ScenarioDir); err != nil {
+		slog.Error("error while loading scenarios", "err", err)
+	}
+
+	if err := prompts.LoadDir(cfg.PromptDir); err != nil {
+		slog.Error("error while loading prompt templates", "err", err)
+	}
+	if cfg.PromptWatch {
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		if err := prompts.Watch(watchCtx, cfg.PromptDir); err != nil {
+			slog.Error("error while watching prompt templates", "err", err)
+		}
+	}
+
+	if err := games.LoadAll(cfg.DataDir); err != nil {
+		slog.Error("error while loading saved games", "err", err)
+	}
 
 	closeChan := make(chan os.Signal, 1)
 	signal.Notify(closeChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
-	s := server.NewServer()
-	defer s.Close()
 	go func() {
 		err := s.Start()
 		if err != nil {
-			fmt.Println("error while http serving:", err.Error())
+			slog.Error("error while http serving", "err", err)
 		}
 	}()
 
 	<-closeChan
+	slog.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		slog.Error("error while shutting down", "err", err)
+	}
 }
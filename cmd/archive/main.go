@@ -0,0 +1,95 @@
+// Command archive moves campaigns between servers. It works on the saved
+// games in the data directory, so the server should not be running.
+//
+//	go run ./cmd/archive [--data dir] export <game-id> [-o file]
+//	go run ./cmd/archive [--data dir] import <file>
+//
+// import also accepts the save file of a game (<data>/<id>.json).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"io"
+	"os"
+)
+
+const usage = "usage: archive [--data dir] export <game-id> [-o file] | import <file>"
+
+func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ai.SetAssetStore(ai.NewDirStore(cfg.AssetDir, cfg.AssetQuota()))
+	games.SetConfig(cfg)
+
+	switch args[0] {
+	case "export":
+		err = exportGame(cfg.DataDir, args[1], args[2:])
+	case "import":
+		err = importGame(cfg.DataDir, args[1])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func exportGame(dir string, id string, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "Output file (default <game-id>.zip, - for stdout)")
+	flags.Parse(args)
+
+	g, err := games.Read(dir, id)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		filename := *output
+		if filename == "" {
+			filename = g.ID + ".zip"
+		}
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		fmt.Fprintln(os.Stderr, filename)
+	}
+	return g.WriteArchive(w)
+}
+
+func importGame(dir string, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	g, err := games.ImportArchiveTo(context.Background(), dir, f, info.Size())
+	if err != nil {
+		return err
+	}
+	fmt.Println(g.ID)
+	return nil
+}
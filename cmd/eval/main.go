@@ -0,0 +1,127 @@
+// Command eval plays the scripted sessions of internal/games/eval against the
+// configured LLM and checks every response with rule-based validators.
+//
+//	go run ./cmd/eval [--data dir] [--prompts dir] -- [-scripts dir] [-only names] [-out dir] [-model name] [-thinking-model name] [-temperature t]
+//
+// The flags after "--" are the ones of this command, the ones before it are
+// the flags of the server. Every run is saved as <out>/<id>.json and
+// <out>/index.html compares all runs in out. The exit code is 1 if a check
+// failed or a script was aborted.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"gameslabor/internal/games/eval"
+	"gameslabor/internal/games/scenarios"
+	"gameslabor/internal/logging"
+	"gameslabor/internal/prompts"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if err := cfg.RequireGoogleAPIKey(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	models := ai.ModelSettings{}
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	scriptDir := flags.String("scripts", "", "Directory with additional scripts as <name>.yaml")
+	only := flags.String("only", "", "Comma separated names of the scripts to run (default all)")
+	out := flags.String("out", filepath.Join(cfg.DataDir, "eval"), "Directory of the reports")
+	flags.StringVar(&models.Model, "model", cfg.LLM.Model, "Model for the turns")
+	flags.StringVar(&models.ThinkingModel, "thinking-model", cfg.LLM.ThinkingModel, "Model for the start of the campaigns")
+	flags.Func("temperature", "Sampling temperature (default from the config)", func(v string) error {
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return err
+		}
+		t := float32(f)
+		models.Temperature = &t
+		return nil
+	})
+	flags.Parse(args)
+
+	if err := run(cfg, *scriptDir, *only, *out, models); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(cfg *config.Config, scriptDir string, only string, out string, models ai.ModelSettings) error {
+	ctx := context.Background()
+	ai.SetAssetStore(ai.NewDirStore(cfg.AssetDir, cfg.AssetQuota()))
+	games.SetConfig(cfg)
+
+	if err := scenarios.LoadDir(cfg.ScenarioDir); err != nil {
+		return err
+	}
+	if err := prompts.LoadDir(cfg.PromptDir); err != nil {
+		return err
+	}
+
+	scripts, err := eval.LoadScripts(scriptDir)
+	if err != nil {
+		return err
+	}
+	if only != "" {
+		names := strings.Split(only, ",")
+		scripts = slices.DeleteFunc(scripts, func(s eval.Script) bool {
+			return !slices.Contains(names, s.Name)
+		})
+	}
+
+	// the report records the temperature even if it is the default
+	if models.Temperature == nil {
+		t := cfg.LLM.Temperature
+		models.Temperature = &t
+	}
+	report, err := eval.Run(ctx, scripts, games.Settings{Models: models})
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(out); err != nil {
+		return err
+	}
+	reports, err := eval.ReadReports(out)
+	if err != nil {
+		return err
+	}
+	if err := eval.WriteHTML(out, reports); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d scripts, %d turns, %s\n", len(report.Scripts), report.Summary.Turns, report.Duration)
+	for _, s := range report.Scripts {
+		if s.Error != "" {
+			fmt.Printf("%-20s aborted: %s\n", s.Name, s.Error)
+		}
+	}
+	for _, v := range report.Summary.Validators {
+		fmt.Printf("%-20s %3d passed %3d failed %3d skipped\n", v.Validator, v.Passed, v.Failed, v.Skipped)
+	}
+	fmt.Printf("\n%s\n", filepath.Join(out, "index.html"))
+
+	if report.Summary.Errors > 0 || report.Summary.Failed() > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
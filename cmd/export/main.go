@@ -0,0 +1,72 @@
+// Command export writes the story of a saved campaign as Markdown, HTML or
+// EPUB.
+//
+//	go run ./cmd/export [--data dir] <game-id> [-format markdown|html|epub] [-gm] [-audio base-url] [-o file]
+//
+// The flags after the game id are the ones of this command, the ones before
+// it are the flags of the server (e.g. --data or --config).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"gameslabor/internal/games/export"
+	"io"
+	"os"
+)
+
+func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: export [--data dir] <game-id> [-format markdown|html|epub] [-gm] [-audio base-url] [-o file]")
+		os.Exit(2)
+	}
+	id := args[0]
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", string(export.Markdown), "Export format (markdown, html, epub)")
+	gm := flags.Bool("gm", false, "Add the game master appendix with the memory of the AI")
+	audio := flags.String("audio", "", "Link the narration audio, served at this base URL (e.g. https://example.com)")
+	output := flags.String("o", "", "Output file (default <title>.<ext>, - for stdout)")
+	flags.Parse(args[1:])
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	g, err := games.Read(cfg.DataDir, id)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		filename := *output
+		if filename == "" {
+			filename = export.Filename(g, format)
+		}
+		f, err := os.Create(filename)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+		fmt.Fprintln(os.Stderr, filename)
+	}
+
+	opts := export.Options{GMAppendix: *gm, Audio: *audio != "", BaseURL: *audio}
+	if err := export.Write(w, g, format, opts); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
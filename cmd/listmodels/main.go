@@ -2,14 +2,11 @@ package main
 
 import (
 	"context"
-	"errors"
-	"flag"
 	"fmt"
-	"gameslabor/internal/env"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/config"
 	"os"
 	"strings"
-
-	"google.golang.org/genai"
 )
 
 var (
@@ -17,45 +14,30 @@ var (
 )
 
 func main() {
-	if flag.NArg() > 0 {
-		filters = flag.Args()
-	}
-
-	ctx := context.Background()
-	c, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  env.GOOGLE_API_KEY,
-		Backend: genai.BackendGeminiAPI,
-	})
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+	if err := cfg.RequireGoogleAPIKey(); err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
-		return
 	}
+	filters = args
 
-	mp, err := c.Models.List(ctx, nil)
+	models, err := ai.ListModels(context.Background(), cfg)
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 		return
 	}
-	for {
-	items:
-		for _, m := range mp.Items {
-			for _, filter := range filters {
-				if !strings.Contains(m.Name, filter) && !strings.Contains(m.DisplayName, filter) {
-					continue items
-				}
-			}
-			fmt.Printf("%s (%s)\n", m.Name, m.DisplayName)
-		}
-		mp, err = mp.Next(ctx)
-		if err != nil {
-			if errors.Is(err, genai.ErrPageDone) {
-				break
+models:
+	for _, m := range models {
+		for _, filter := range filters {
+			if !strings.Contains(m.Name, filter) && !strings.Contains(m.DisplayName, filter) {
+				continue models
 			}
-			fmt.Println(err.Error())
-			os.Exit(1)
-			return
 		}
+		fmt.Printf("%s (%s)\n", m.Name, m.DisplayName)
 	}
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// wrap breaks text into lines of at most width runes, at spaces if possible.
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		// the indentation of JSON is kept on every line of the paragraph
+		indent := paragraph[:min(len(paragraph)-len(strings.TrimLeft(paragraph, " ")), width/2)]
+		line := []rune(indent)
+		empty := true
+		for _, word := range strings.Fields(paragraph) {
+			w := []rune(word)
+			if !empty && len(line)+1+len(w) > width {
+				lines = append(lines, string(line))
+				line, empty = []rune(indent), true
+			}
+			if !empty {
+				line = append(line, ' ')
+			}
+			for len(line)+len(w) > width {
+				n := width - len(line)
+				lines = append(lines, string(line)+string(w[:n]))
+				line, w = []rune(indent), w[n:]
+			}
+			line = append(line, w...)
+			empty = false
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+type diffOp uint8
+
+const (
+	diffEqual diffOp = iota
+	diffLeft
+	diffRight
+)
+
+// diffLines returns the operations that turn a into b, based on the longest
+// common subsequence of the lines.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffEqual)
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffLeft)
+			i++
+		default:
+			ops = append(ops, diffRight)
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffLeft)
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffRight)
+	}
+	return ops
+}
+
+// sideBySide writes a and b next to each other like diff -y, each side is
+// wrapped to half of width. "|" marks changed lines, "<" lines only on the
+// left and ">" lines only on the right.
+func sideBySide(w io.Writer, a, b string, width int) {
+	column := max((width-3)/2, 10)
+	left, right := wrap(a, column), wrap(b, column)
+
+	ops := diffLines(left, right)
+	i, j := 0, 0
+	for k := 0; k < len(ops); {
+		if ops[k] == diffEqual {
+			fmt.Fprintf(w, "%-*s   %s\n", column, left[i], right[j])
+			i++
+			j++
+			k++
+			continue
+		}
+
+		// a block of removed and added lines is shown as changed lines
+		var removed, added []string
+		for ; k < len(ops) && ops[k] != diffEqual; k++ {
+			if ops[k] == diffLeft {
+				removed = append(removed, left[i])
+				i++
+			} else {
+				added = append(added, right[j])
+				j++
+			}
+		}
+		for n := 0; n < max(len(removed), len(added)); n++ {
+			switch {
+			case n < len(removed) && n < len(added):
+				fmt.Fprintf(w, "%-*s | %s\n", column, removed[n], added[n])
+			case n < len(removed):
+				fmt.Fprintf(w, "%-*s <\n", column, removed[n])
+			default:
+				fmt.Fprintf(w, "%-*s > %s\n", column, "", added[n])
+			}
+		}
+	}
+}
@@ -1,35 +1,211 @@
+// Command workbench replays a turn of a saved campaign with other prompt
+// templates, models or sampling settings, to tune prompts against real
+// campaign data.
+//
+//	go run ./cmd/workbench [--data dir] <game-id> [-turn n] [-savepoint id] [-prompts dir] [-model name] [-thinking-model name] [-temperature t] [-thinking-budget n] [-max-output-tokens n] [-width n]
+//
+// The flags after the game id are the ones of this command, the ones before
+// it are the flags of the server (e.g. --data or --prompts). The turn is run
+// twice: on the left with the prompt templates of the server and the model
+// settings of the campaign, on the right with the flags of this command. The
+// prompts, the narrator texts and the responses are printed side by side.
+//
+// Only the chat history is cut before the turn, the memory of the AI is the
+// saved one. A save point taken right before the turn replays it faithfully.
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
 	"fmt"
 	"gameslabor/internal/ai"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"gameslabor/internal/prompts"
 	"os"
-	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 func main() {
-	aiInstalce, err := ai.New(context.Background())
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
 	}
-	defer aiInstalce.Close()
+	if err := cfg.RequireGoogleAPIKey(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: workbench [--data dir] <game-id> [-turn n] [-savepoint id] [-prompts dir] [-model name] [-thinking-model name] [-temperature t] [-thinking-budget n] [-max-output-tokens n] [-width n]")
+		os.Exit(2)
+	}
+	id := args[0]
+
+	variant := ai.ModelSettings{}
+	flags := flag.NewFlagSet("workbench", flag.ExitOnError)
+	turn := flags.Int("turn", 0, "Turn to replay, 1 is the start of the campaign (default the last turn)")
+	savePoint := flags.String("savepoint", "", "Replay from the state of this save point instead of the current one")
+	promptDir := flags.String("prompts", "", "Directory with the prompt templates of the right side as <language>/<name>.tmpl")
+	flags.StringVar(&variant.Model, "model", "", "Model of the right side for the turns")
+	flags.StringVar(&variant.ThinkingModel, "thinking-model", "", "Model of the right side for the start of the campaign")
+	flags.Func("temperature", "Sampling temperature of the right side", func(v string) error {
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return err
+		}
+		t := float32(f)
+		variant.Temperature = &t
+		return nil
+	})
+	flags.Func("thinking-budget", "Thinking budget of the right side, -1 lets the model decide", func(v string) error {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		budget := int32(i)
+		variant.ThinkingBudget = &budget
+		return nil
+	})
+	flags.Func("max-output-tokens", "Maximum tokens of the response of the right side", func(v string) error {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		variant.MaxOutputTokens = int32(i)
+		return nil
+	})
+	width := flags.Int("width", 160, "Width of the output")
+	flags.Parse(args[1:])
+
+	if err := run(cfg, id, *turn, *savePoint, *promptDir, variant, *width); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
 
-	defer ai.Cleanup()
+func run(cfg *config.Config, id string, turn int, savePoint string, promptDir string, variant ai.ModelSettings, width int) error {
+	ctx := context.Background()
+	ai.SetAssetStore(ai.NewDirStore(cfg.AssetDir, cfg.AssetQuota()))
 
-	audioFileName, err := aiInstalce.TTS(`Ihr stoßt die knarrende Tür zur Spelunke "Zum salzigen Seeteufel" auf und eine Welle aus abgestandenem Rum, Schweiß und dem salzigen Geruch geteerter Taue schlägt euch entgegen.`)
+	left, err := prompts.LoadSet(cfg.PromptDir)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	right := left
+	if promptDir != "" {
+		if _, err := os.Stat(promptDir); err != nil {
+			return err
+		}
+		if right, err = prompts.LoadSet(promptDir); err != nil {
+			return err
+		}
 	}
 
-	audioFileName = ai.FullFilename(audioFileName)
-	fmt.Println(audioFileName)
+	g, err := games.Read(cfg.DataDir, id)
+	if err != nil {
+		return err
+	}
+	if g.AI == nil {
+		return fmt.Errorf("game %s has not started", id)
+	}
+	memory, players := g.AI, g.Players
+	// the save point closest after the turn has the memory closest to the
+	// one it was played with
+	if savePoint == "" {
+		savePoint = nearestSavePoint(g, turn)
+		if savePoint != "" {
+			fmt.Printf("Using save point %s\n", savePoint)
+		}
+	}
+	if savePoint != "" {
+		sp, err := games.ReadSavePoint(cfg.DataDir, id, savePoint)
+		if err != nil {
+			return err
+		}
+		memory, players = sp.AI, sp.Players
+	}
+	if memory == nil {
+		return fmt.Errorf("game %s has not started", id)
+	}
 
-	cmd := exec.Command("ffplay", "-nodisp", "-autoexit", "-nodisp", "-autoexit", audioFileName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	r, err := newReplay(g.Settings, memory, players, turn)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	leftModels := memory.Models
+	rightModels := merge(leftModels, variant)
+	if err := ai.ValidateModelSettings(ctx, cfg, rightModels); err != nil {
+		return err
 	}
+
+	fmt.Printf("Turn %d of %d (%s)\n\n", r.Turn, r.Turns, r.Kind)
+	if r.Warning != "" {
+		fmt.Printf("Warning: %s\n\n", r.Warning)
+	}
+	fmt.Printf("%-*s   %s\n", (width-3)/2, describe(cfg, cfg.PromptDir, leftModels, r.Kind), describe(cfg, cmp.Or(promptDir, cfg.PromptDir), rightModels, r.Kind))
+
+	var results [2]result
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		results[0] = r.run(ctx, cfg, left, leftModels)
+	}()
+	go func() {
+		defer wg.Done()
+		results[1] = r.run(ctx, cfg, right, rightModels)
+	}()
+	wg.Wait()
+	for _, res := range results {
+		if res.Err != nil {
+			return res.Err
+		}
+	}
+
+	heading("Prompt", width)
+	sideBySide(os.Stdout, results[0].Prompt, results[1].Prompt, width)
+	heading("Recorded narrator_text", width)
+	fmt.Println(strings.Join(wrap(r.Recorded.Message, width), "\n"))
+	heading("narrator_text", width)
+	sideBySide(os.Stdout, results[0].Response.NarratorText, results[1].Response.NarratorText, width)
+	heading("Response", width)
+	sideBySide(os.Stdout, results[0].Response.JSON(), results[1].Response.JSON(), width)
+	return nil
+}
+
+// merge returns the settings of the campaign with the fields set in variant
+// replaced.
+func merge(settings ai.ModelSettings, variant ai.ModelSettings) ai.ModelSettings {
+	settings.Model = cmp.Or(variant.Model, settings.Model)
+	settings.ThinkingModel = cmp.Or(variant.ThinkingModel, settings.ThinkingModel)
+	if variant.Temperature != nil {
+		settings.Temperature = variant.Temperature
+	}
+	if variant.ThinkingBudget != nil {
+		settings.ThinkingBudget = variant.ThinkingBudget
+	}
+	settings.MaxOutputTokens = cmp.Or(variant.MaxOutputTokens, settings.MaxOutputTokens)
+	return settings
+}
+
+// describe names the prompt templates and the model of a side.
+func describe(cfg *config.Config, promptDir string, models ai.ModelSettings, kind string) string {
+	model := cmp.Or(models.Model, cfg.LLM.Model)
+	if kind == prompts.Start {
+		model = cmp.Or(models.ThinkingModel, cfg.LLM.ThinkingModel)
+	}
+	temperature := cfg.LLM.Temperature
+	if models.Temperature != nil {
+		temperature = *models.Temperature
+	}
+	return fmt.Sprintf("%s, %s, temperature %g", promptDir, model, temperature)
+}
+
+func heading(title string, width int) {
+	fmt.Printf("\n== %s %s\n", title, strings.Repeat("=", max(width-len(title)-4, 0)))
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/prompts"
+)
+
+// replay is a turn of a saved campaign that is run again.
+type replay struct {
+	// Turn is the number of the turn, 1 is the start of the campaign.
+	Turn  int
+	Turns int
+	// Kind is the name of the prompt template that produced the turn.
+	Kind string
+	// Recorded is the message of the narrator that was saved for the turn.
+	Recorded ai.ChatMessage
+	// Warning is set if the memory is not the one the turn was played
+	// with, because it already knows later turns.
+	Warning string
+
+	// memory is the AI as JSON with the chat history cut before the turn,
+	// every run gets its own copy.
+	memory []byte
+	// prompt renders the prompt of the turn with the templates of a.
+	prompt func(a *ai.AI) (string, error)
+}
+
+// turnIndex returns the index of the message of the narrator of turn in
+// history, turn 0 is the last one, together with the number of the turn and
+// the number of turns.
+func turnIndex(history []ai.ChatMessage, turn int) (int, int, int, error) {
+	var turns []int
+	for i, m := range history {
+		if m.Role == "model" {
+			turns = append(turns, i)
+		}
+	}
+	if len(turns) == 0 {
+		return 0, 0, 0, errors.New("the campaign has no turns yet")
+	}
+	if turn == 0 {
+		turn = len(turns)
+	}
+	if turn < 1 || turn > len(turns) {
+		return 0, 0, 0, fmt.Errorf("turn %d does not exist, the campaign has %d turns", turn, len(turns))
+	}
+	return turns[turn-1], turn, len(turns), nil
+}
+
+// nearestSavePoint returns the id of the save point of g whose memory knows
+// the least about the turns after turn, or "" if no save point that
+// contains the turn has fewer messages than g.
+func nearestSavePoint(g *games.Game, turn int) string {
+	index, _, _, err := turnIndex(g.AI.ChatHistory, turn)
+	if err != nil {
+		return ""
+	}
+	nearest, length := "", len(g.AI.ChatHistory)
+	for _, sp := range g.SavePoints {
+		if sp.ChatLength > index && sp.ChatLength < length {
+			nearest, length = sp.ID, sp.ChatLength
+		}
+	}
+	return nearest
+}
+
+// newReplay finds the prompt of the turn of the campaign, turn 0 is the last
+// one. The chat history is cut before the turn. If it is the last turn of
+// memory, what its response added to the memory is reverted, see
+// ai.AI.RevertLastResponse, otherwise the memory already knows later turns
+// and Warning tells so.
+func newReplay(settings games.Settings, memory *ai.AI, players map[string]*games.Player, turn int) (*replay, error) {
+	index, turn, turns, err := turnIndex(memory.ChatHistory, turn)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replay{Turn: turn, Turns: turns, Recorded: memory.ChatHistory[index]}
+	var prev ai.ChatMessage
+	if index > 0 {
+		prev = memory.ChatHistory[index-1]
+	}
+	switch {
+	case index == 0:
+		scenario, err := settings.ResolveScenario()
+		if err != nil {
+			return nil, err
+		}
+		language, err := locale.Get(settings.Language)
+		if err != nil {
+			return nil, err
+		}
+		data := settings.StartData(scenario, language, players)
+		r.Kind = prompts.Start
+		r.prompt = func(a *ai.AI) (string, error) {
+			return a.Prompt(prompts.Start, data)
+		}
+	case prev.Role == "user":
+		player, ok := players[prev.PlayerID]
+		if !ok {
+			player = &games.Player{ID: prev.PlayerID}
+		}
+		r.Kind = games.InputTemplate(prev.Message)
+		r.prompt = func(a *ai.AI) (string, error) {
+			_, prompt, err := games.InputPrompt(a, player.Prompt(), prev.Message)
+			return prompt, err
+		}
+	case prev.Roll != nil:
+		data := prompts.RollData{Result: prev.Roll.Result, Difficulty: prev.Roll.Difficulty}
+		r.Kind = prompts.RollFailure
+		if data.Result >= data.Difficulty {
+			r.Kind = prompts.RollSuccess
+		}
+		r.prompt = func(a *ai.AI) (string, error) {
+			return a.Prompt(r.Kind, data)
+		}
+	default:
+		return nil, fmt.Errorf("turn %d follows neither an input nor a roll", turn)
+	}
+
+	cut := memory.Copy()
+	if later := turns - turn; later > 0 {
+		r.Warning = fmt.Sprintf("the memory is from %d turns later and already knows what happens next", later)
+	} else {
+		cut.RevertLastResponse()
+	}
+	cut.ChatHistory = cut.ChatHistory[:index]
+	b, err := json.Marshal(cut)
+	if err != nil {
+		return nil, err
+	}
+	r.memory = b
+	return r, nil
+}
+
+// result is one run of a replay.
+type result struct {
+	Prompt   string
+	Response ai.ResponseSchema
+	Err      error
+}
+
+// run plays the turn again with the prompt templates and model settings.
+func (r *replay) run(ctx context.Context, cfg *config.Config, set *prompts.Set, models ai.ModelSettings) result {
+	a := &ai.AI{}
+	if err := json.Unmarshal(r.memory, a); err != nil {
+		return result{Err: err}
+	}
+	a.Models = models
+	a.SetPrompts(set)
+
+	prompt, err := r.prompt(a)
+	if err != nil {
+		return result{Err: err}
+	}
+	if err := a.Connect(ctx, cfg); err != nil {
+		return result{Prompt: prompt, Err: err}
+	}
+	defer a.Close()
+
+	if r.Kind == prompts.Start {
+		return result{Prompt: prompt, Response: a.Start(ctx, prompt)}
+	}
+	return result{Prompt: prompt, Response: a.Continue(ctx, prompt)}
+}
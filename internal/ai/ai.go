@@ -3,22 +3,45 @@ package ai
 import (
 	"context"
 	"errors"
-	"gameslabor/internal/env"
+	"gameslabor/internal/config"
+	"gameslabor/internal/prompts"
+	"maps"
+	"slices"
+	"sync"
 
-	tts "cloud.google.com/go/texttospeech/apiv1"
-	"google.golang.org/api/option"
 	"google.golang.org/genai"
 )
 
 type AI struct {
-	ctx               context.Context     `json:"-"`
-	llmClient         *genai.Client       `json:"-"`
-	ttsClient         *tts.Client         `json:"-"`
+	llmClient   *genai.Client  `json:"-"`
+	speaker     Speaker        `json:"-"`
+	illustrator Illustrator    `json:"-"`
+	cfg         *config.Config `json:"-"`
+	// prompts overrides the current prompt templates, see SetPrompts.
+	prompts *prompts.Set `json:"-"`
+	// locker is released while waiting for the LLM, see SetLocker.
+	locker sync.Locker `json:"-"`
+	// GameID names the folder the assets of the AI are stored in.
+	GameID     string `json:"game_id"`
+	TTSBackend string `json:"tts_backend"`
+	// Language is the code of the campaign language, see locale.Get.
+	Language          string              `json:"language"`
 	EventPlan         []string            `json:"event_plan"`
 	EventLongHistory  []string            `json:"event_long_history"`
 	EventShortHistory []string            `json:"event_short_history"`
 	ChatHistory       []ChatMessage       `json:"chat_history"`
 	EntityData        map[string][]string `json:"entity_data"`
+	// Place is where the players currently are.
+	Place string `json:"place"`
+	// Voices maps entities to the TTS voice they speak with.
+	Voices map[string]string `json:"voices"`
+	// Models are the model settings of the game.
+	Models ModelSettings `json:"models"`
+	// Boundaries are the lines and veils of the table.
+	Boundaries Boundaries `json:"boundaries"`
+	// LastResponse is what the last response added to the memory, nil if it
+	// added nothing, see RevertLastResponse.
+	LastResponse *MemoryMark `json:"last_response,omitempty"`
 }
 
 var (
@@ -37,27 +60,89 @@ func Empty() *AI {
 	}
 }
 
-func New(ctx context.Context) (*AI, error) {
-	llmClient, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  env.GOOGLE_API_KEY,
-		Backend: genai.BackendGeminiAPI,
-	})
-	if err != nil {
-		return nil, errors.Join(errors.New("failed to create gemini client"), err)
+// Copy returns a copy of the memory and settings of the AI that shares
+// nothing with it, e.g. to read it while the next turn changes the AI.
+// The copy is not connected.
+func (ai *AI) Copy() *AI {
+	c := &AI{
+		cfg:               ai.cfg,
+		prompts:           ai.prompts,
+		GameID:            ai.GameID,
+		TTSBackend:        ai.TTSBackend,
+		Language:          ai.Language,
+		EventPlan:         slices.Clone(ai.EventPlan),
+		EventLongHistory:  slices.Clone(ai.EventLongHistory),
+		EventShortHistory: slices.Clone(ai.EventShortHistory),
+		ChatHistory:       slices.Clone(ai.ChatHistory),
+		EntityData:        make(map[string][]string, len(ai.EntityData)),
+		Place:             ai.Place,
+		Voices:            maps.Clone(ai.Voices),
+		Models:            ai.Models,
+		Boundaries: Boundaries{
+			Lines:   slices.Clone(ai.Boundaries.Lines),
+			Veils:   slices.Clone(ai.Boundaries.Veils),
+			XCarded: slices.Clone(ai.Boundaries.XCarded),
+		},
 	}
-	ttsClient, err := tts.NewClient(ctx, option.WithAPIKey(env.GOOGLE_API_KEY))
-	if err != nil {
-		return nil, errors.Join(errors.New("failed to create tts client"), err)
+	for name, data := range ai.EntityData {
+		c.EntityData[name] = slices.Clone(data)
+	}
+	if ai.LastResponse != nil {
+		mark := *ai.LastResponse
+		mark.EntityData = maps.Clone(mark.EntityData)
+		c.LastResponse = &mark
 	}
+	// the paths of the audio segments are renamed in place
+	for i, m := range c.ChatHistory {
+		c.ChatHistory[i].AudioSegments = slices.Clone(m.AudioSegments)
+	}
+	return c
+}
 
-	return &AI{
-		ctx:               ctx,
-		llmClient:         llmClient,
-		ttsClient:         ttsClient,
+// New creates the AI of a game that speaks through the given TTS backend and
+// plays in the given language. An empty ttsBackend selects the default of cfg,
+// an empty language the default language.
+func New(ctx context.Context, cfg *config.Config, gameID string, ttsBackend string, language string) (*AI, error) {
+	ai := &AI{
+		GameID:            gameID,
+		TTSBackend:        ttsBackend,
+		Language:          language,
 		EventPlan:         make([]string, 0),
 		EventLongHistory:  make([]string, 0),
 		EventShortHistory: make([]string, 0),
 		ChatHistory:       make([]ChatMessage, 0),
 		EntityData:        make(map[string][]string),
-	}, nil
+		Voices:            make(map[string]string),
+	}
+	if err := ai.Connect(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return ai, nil
+}
+
+// Connect creates the clients for the LLM and the TTS backend.
+// This is needed for an AI restored from a saved game state.
+func (ai *AI) Connect(ctx context.Context, cfg *config.Config) error {
+	llmClient, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  cfg.GoogleAPIKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return errors.Join(errors.New("failed to create gemini client"), err)
+	}
+	speaker, err := newSpeaker(ctx, cfg, ai.TTSBackend, ai.Locale())
+	if err != nil {
+		return err
+	}
+	illustrator, err := newIllustrator(ctx, cfg)
+	if err != nil {
+		_ = speaker.Close()
+		return err
+	}
+
+	ai.cfg = cfg
+	ai.llmClient = llmClient
+	ai.speaker = speaker
+	ai.illustrator = illustrator
+	return nil
 }
@@ -0,0 +1,319 @@
+package ai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gameslabor/internal/config"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	unixpath "path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssetStore keeps the files generated for games, like audio.
+// Assets are served at /ai/<game>/<name>, where name is derived from the
+// content, so an asset never changes once it is stored.
+type AssetStore interface {
+	// Put stores data in the folder of game and returns the path it is
+	// served at. Storing the same data twice returns the same path.
+	Put(game string, ext string, data []byte) (string, error)
+	// Open opens the asset served at path.
+	Open(path string) (io.ReadSeekCloser, time.Time, error)
+	Exists(path string) bool
+	// Full reports whether game used up its quota, so generating more
+	// assets for it is wasted.
+	Full(game string) bool
+	// DeleteGame removes all assets of game.
+	DeleteGame(game string) error
+}
+
+var (
+	ErrInvalidAssetPath   = errors.New("invalid asset path")
+	ErrAssetQuotaExceeded = errors.New("asset quota of game exceeded")
+
+	// assets is replaced by the commands with SetAssetStore, the default is
+	// the asset dir of config.Default.
+	assets AssetStore = NewDirStore(filepath.Join(config.Default().DataDir, "assets"), config.Default().AssetQuota())
+
+	assetGamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	assetNamePattern = regexp.MustCompile(`^[0-9a-f]{64}\.[a-z0-9]+$`)
+)
+
+// SetAssetStore replaces the store generated files are written to.
+func SetAssetStore(store AssetStore) {
+	assets = store
+}
+
+// AssetsFull reports whether the game of the AI used up its asset quota.
+// Nothing is generated for it then, see TTS and Illustrate.
+func (ai *AI) AssetsFull() bool {
+	return assets.Full(ai.GameID)
+}
+
+// DeleteGameAssets removes all files generated for game.
+func DeleteGameAssets(game string) error {
+	forgetAudio(game)
+	return assets.DeleteGame(game)
+}
+
+// OpenAsset opens the asset served at path.
+func OpenAsset(path string) (io.ReadSeekCloser, time.Time, error) {
+	return assets.Open(path)
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	f, modTime, err := assets.Open(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	name := unixpath.Base(r.URL.Path)
+	if contentType := assetContentType(name); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// names are content addressed, so clients can keep assets forever
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(name, unixpath.Ext(name))+`"`)
+	// ServeContent answers range and conditional requests
+	http.ServeContent(w, r, name, modTime, f)
+}
+
+func assetContentType(name string) string {
+	switch ext := unixpath.Ext(name); ext {
+	case ".ogg":
+		return "audio/ogg"
+	default:
+		return mime.TypeByExtension(ext)
+	}
+}
+
+// PutAsset stores data in the folder of game and returns the path it is
+// served at, e.g. for assets of an imported game.
+func PutAsset(game string, ext string, data []byte) (string, error) {
+	return assets.Put(game, ext, data)
+}
+
+// Assets returns the paths of all files the chat history refers to.
+func (ai *AI) Assets() []string {
+	var paths []string
+	for _, m := range ai.ChatHistory {
+		for _, p := range append([]string{m.Audio, m.Image}, m.AudioSegments...) {
+			if p != "" && !slices.Contains(paths, p) {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// RenameAssets replaces the paths in the chat history by the ones in
+// renamed, e.g. after the assets were stored for another game.
+func (ai *AI) RenameAssets(renamed map[string]string) {
+	rename := func(p string) string {
+		if newPath, ok := renamed[p]; ok {
+			return newPath
+		}
+		return p
+	}
+	for i, m := range ai.ChatHistory {
+		ai.ChatHistory[i].Audio = rename(m.Audio)
+		ai.ChatHistory[i].Image = rename(m.Image)
+		for j, segment := range m.AudioSegments {
+			ai.ChatHistory[i].AudioSegments[j] = rename(segment)
+		}
+	}
+}
+
+// CopyAssets stores the files the chat history refers to in the folder of
+// game and points the history to the copies, e.g. for a forked game. Missing
+// files are skipped, see DropMissingAssets.
+func (ai *AI) CopyAssets(game string) error {
+	renamed := make(map[string]string)
+	for _, path := range ai.Assets() {
+		f, _, err := assets.Open(path)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		newPath, err := assets.Put(game, unixpath.Ext(path), data)
+		if err != nil {
+			return err
+		}
+		renamed[path] = newPath
+	}
+	ai.RenameAssets(renamed)
+	return nil
+}
+
+// DropMissingAssets removes references to files that don't exist anymore
+// (e.g. after they were deleted), so they are generated again.
+func (ai *AI) DropMissingAssets() {
+	for i, m := range ai.ChatHistory {
+		if m.Image != "" && !assets.Exists(m.Image) {
+			ai.ChatHistory[i].Image = ""
+		}
+		if m.Audio == "" && len(m.AudioSegments) == 0 {
+			continue
+		}
+		missing := false
+		for _, audio := range append([]string{m.Audio}, m.AudioSegments...) {
+			if audio != "" && !assets.Exists(audio) {
+				missing = true
+			}
+		}
+		if missing {
+			ai.ChatHistory[i].Audio = ""
+			ai.ChatHistory[i].AudioSegments = nil
+		}
+	}
+}
+
+func (ai *AI) saveOgg(audio []byte) (string, error) {
+	return assets.Put(ai.GameID, ".ogg", audio)
+}
+
+// DirStore stores assets in a directory on disk with one folder per game.
+type DirStore struct {
+	dir string
+	// quota is the maximum number of bytes per game, 0 is unlimited.
+	quota int64
+
+	mut sync.Mutex
+	// usage is the number of bytes per game, it is read from disk on the
+	// first write to a game.
+	usage map[string]int64
+	// full are the games an asset was rejected for by the quota.
+	full map[string]bool
+}
+
+func NewDirStore(dir string, quota int64) *DirStore {
+	return &DirStore{dir: dir, quota: quota, usage: make(map[string]int64), full: make(map[string]bool)}
+}
+
+func (s *DirStore) Put(game string, ext string, data []byte) (string, error) {
+	if !assetGamePattern.MatchString(game) {
+		return "", fmt.Errorf("%w: game %q", ErrInvalidAssetPath, game)
+	}
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + ext
+	servePath := unixpath.Join("/ai", game, name)
+	filename := filepath.Join(s.dir, game, name)
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, err := os.Stat(filename); err == nil {
+		return servePath, nil
+	}
+
+	usage, ok := s.usage[game]
+	if !ok {
+		usage = dirSize(filepath.Join(s.dir, game))
+	}
+	if s.quota > 0 && usage+int64(len(data)) > s.quota {
+		s.full[game] = true
+		return "", ErrAssetQuotaExceeded
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return "", err
+	}
+	// write to a temporary file first so a crash can't leave a broken asset behind
+	tmpFilename := filename + ".tmp"
+	if err := os.WriteFile(tmpFilename, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return "", err
+	}
+	s.usage[game] = usage + int64(len(data))
+	return servePath, nil
+}
+
+func (s *DirStore) Open(path string) (io.ReadSeekCloser, time.Time, error) {
+	filename, err := s.filename(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+func (s *DirStore) Exists(path string) bool {
+	filename, err := s.filename(path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filename)
+	return err == nil
+}
+
+// Full reports whether an asset of game was rejected by the quota. Smaller
+// assets might still fit, but a game that reached its quota gets no more.
+func (s *DirStore) Full(game string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.full[game]
+}
+
+func (s *DirStore) DeleteGame(game string) error {
+	if !assetGamePattern.MatchString(game) {
+		return fmt.Errorf("%w: game %q", ErrInvalidAssetPath, game)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.usage, game)
+	delete(s.full, game)
+	return os.RemoveAll(filepath.Join(s.dir, game))
+}
+
+// filename maps a served path to the file on disk. Only paths of the form
+// /ai/<game>/<name> are accepted, so no file outside of dir can be read.
+func (s *DirStore) filename(path string) (string, error) {
+	game, name, ok := strings.Cut(strings.TrimPrefix(path, "/ai/"), "/")
+	if !ok || !assetGamePattern.MatchString(game) || !assetNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAssetPath, path)
+	}
+	return filepath.Join(s.dir, game, name), nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
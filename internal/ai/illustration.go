@@ -0,0 +1,85 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/metrics"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Illustrator is an image generation backend for scene illustrations.
+type Illustrator interface {
+	// Name identifies the backend, e.g. in logs and metrics.
+	Name() string
+	// Illustrate draws scene and returns the image and its file extension.
+	Illustrate(ctx context.Context, scene string) ([]byte, string, error)
+	Close() error
+}
+
+type illustratorFactory func(ctx context.Context, cfg *config.Config) (Illustrator, error)
+
+var (
+	ErrUnknownIllustrator = errors.New("unknown image backend")
+
+	illustratorFactories = map[string]illustratorFactory{}
+)
+
+// registerIllustrator makes an image backend selectable by name.
+func registerIllustrator(name string, factory illustratorFactory) {
+	illustratorFactories[name] = factory
+}
+
+// Illustrators returns the names of all image backends that can be used.
+func Illustrators() []string {
+	names := make([]string, 0, len(illustratorFactories))
+	for name := range illustratorFactories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newIllustrator returns nil if scene illustrations are turned off.
+func newIllustrator(ctx context.Context, cfg *config.Config) (Illustrator, error) {
+	if cfg.Images.Backend == "" {
+		return nil, nil
+	}
+	factory, ok := illustratorFactories[cfg.Images.Backend]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownIllustrator, cfg.Images.Backend)
+	}
+	return factory(ctx, cfg)
+}
+
+// CanIllustrate reports whether an image backend is connected.
+func (ai *AI) CanIllustrate() bool {
+	return ai.illustrator != nil
+}
+
+// Illustrate generates an image of scene and returns the path it is served at.
+// It is safe to call Illustrate concurrently.
+func (ai *AI) Illustrate(ctx context.Context, scene string) (string, error) {
+	illustrator := ai.illustrator
+	if illustrator == nil {
+		return "", errors.New("no image backend connected")
+	}
+	name := illustrator.Name()
+	if ai.AssetsFull() {
+		return "", ErrAssetQuotaExceeded
+	}
+
+	start := time.Now()
+	image, ext, err := illustrator.Illustrate(ctx, scene)
+	latency := time.Since(start)
+	metrics.ImageDuration.WithLabelValues(name).Observe(latency.Seconds())
+	if err != nil {
+		metrics.ImageErrors.WithLabelValues(name).Inc()
+		return "", errors.Join(errors.New("failed to generate image"), err)
+	}
+	slog.InfoContext(ctx, "generated image", "backend", name, "latency", latency, "bytes", len(image))
+	return assets.Put(ai.GameID, ext, image)
+}
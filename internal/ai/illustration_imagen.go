@@ -0,0 +1,60 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"gameslabor/internal/config"
+
+	"google.golang.org/genai"
+)
+
+// imagenStyle is put in front of every scene to keep the illustrations of a
+// campaign in the same style.
+const imagenStyle = "Digitale Illustration für ein Pen-and-Paper Rollenspiel, stimmungsvolles Licht, keine Schrift im Bild. Szene: "
+
+func init() {
+	registerIllustrator("imagen", newImagenIllustrator)
+}
+
+// imagenIllustrator generates images with Imagen through the Gemini API.
+type imagenIllustrator struct {
+	client *genai.Client
+	model  string
+}
+
+func newImagenIllustrator(ctx context.Context, cfg *config.Config) (Illustrator, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  cfg.GoogleAPIKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create imagen client"), err)
+	}
+	return &imagenIllustrator{client: client, model: cfg.Images.Model}, nil
+}
+
+func (i *imagenIllustrator) Name() string {
+	return "imagen"
+}
+
+func (i *imagenIllustrator) Illustrate(ctx context.Context, scene string) ([]byte, string, error) {
+	resp, err := i.client.Models.GenerateImages(ctx, i.model, imagenStyle+scene, &genai.GenerateImagesConfig{
+		NumberOfImages: 1,
+		AspectRatio:    "16:9",
+		OutputMIMEType: "image/jpeg",
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(resp.GeneratedImages) == 0 || resp.GeneratedImages[0].Image == nil {
+		return nil, "", errors.New("no image generated")
+	}
+	if reason := resp.GeneratedImages[0].RAIFilteredReason; reason != "" {
+		return nil, "", errors.New("image filtered: " + reason)
+	}
+	return resp.GeneratedImages[0].Image.ImageBytes, ".jpg", nil
+}
+
+func (i *imagenIllustrator) Close() error {
+	return nil
+}
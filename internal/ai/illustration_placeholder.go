@@ -0,0 +1,53 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"gameslabor/internal/config"
+	"hash/fnv"
+	"html"
+	"strings"
+)
+
+// placeholderMaxText is the number of characters of the scene that are
+// written on a placeholder image.
+const placeholderMaxText = 80
+
+func init() {
+	registerIllustrator("placeholder", func(ctx context.Context, cfg *config.Config) (Illustrator, error) {
+		return placeholderIllustrator{}, nil
+	})
+}
+
+// placeholderIllustrator draws an SVG gradient with the scene written on it.
+// It works offline and is meant for development.
+type placeholderIllustrator struct{}
+
+func (placeholderIllustrator) Name() string {
+	return "placeholder"
+}
+
+func (placeholderIllustrator) Illustrate(ctx context.Context, scene string) ([]byte, string, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(scene))
+	hue := h.Sum32() % 360
+
+	text := strings.TrimSpace(scene)
+	if runes := []rune(text); len(runes) > placeholderMaxText {
+		text = string(runes[:placeholderMaxText-1]) + "…"
+	}
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="1280" height="720" viewBox="0 0 1280 720">
+<defs><linearGradient id="g" x1="0" y1="0" x2="1" y2="1">
+<stop offset="0" stop-color="hsl(%d,45%%,35%%)"/><stop offset="1" stop-color="hsl(%d,45%%,12%%)"/>
+</linearGradient></defs>
+<rect width="1280" height="720" fill="url(#g)"/>
+<text x="640" y="360" fill="#f5f5f4" font-family="sans-serif" font-size="32" text-anchor="middle">%s</text>
+</svg>
+`, hue, (hue+40)%360, html.EscapeString(text))
+	return []byte(svg), ".svg", nil
+}
+
+func (placeholderIllustrator) Close() error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package ai
+
+import (
+	"gameslabor/internal/locale"
+	"strings"
+	"unicode"
+)
+
+// schemaFields are the fields of the response and the game data, players
+// have no reason to name them in the story. Fields like place or scene are
+// common words and left out.
+var schemaFields = []string{
+	"narrator_text",
+	"narrator_markup",
+	"dramatic_moment",
+	"event_plan",
+	"event_long_history",
+	"event_short_history",
+	"entity_data",
+	"roll_dice",
+	"recent_chat_history",
+}
+
+// SanitizeInput removes control and invisible formatting characters from the
+// input of a player, except line breaks and tabs, and trims it. Runs of empty
+// lines are reduced to one.
+func SanitizeInput(input string) string {
+	input = strings.ToValidUTF8(input, "")
+	input = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		// the zero width joiner combines emoji
+		case r == '\u200d':
+			return r
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, input)
+	for strings.Contains(input, "\n\n\n") {
+		input = strings.ReplaceAll(input, "\n\n\n", "\n\n")
+	}
+	return strings.TrimSpace(input)
+}
+
+// OutOfCharacter returns the phrase with which input tries to talk to the LLM
+// instead of the narrator, e.g. to change its instructions or the game data,
+// or "" if it is an input of the game. The phrases of all languages are
+// looked for, see locale.InputTexts, and the fields of the response.
+func OutOfCharacter(input string) string {
+	input = strings.Join(strings.Fields(input), " ")
+	for _, l := range locale.List() {
+		for _, phrase := range l.Input.OutOfCharacter {
+			if containsWord(input, phrase) {
+				return phrase
+			}
+		}
+	}
+	for _, field := range schemaFields {
+		if containsWord(input, field) {
+			return field
+		}
+	}
+	return ""
+}
@@ -1,21 +1,22 @@
 package ai
 
 import (
-	_ "embed"
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/prompts"
+	"log/slog"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/genai"
 )
 
-const (
-	mainModel     = "gemini-2.5-flash"
-	thinkingModel = "gemini-2.5-flash"
-)
-
 type (
 	EntityData struct {
 		EntityName string `json:"entity"`
@@ -29,12 +30,20 @@ type (
 
 	// ResponseSchema corresponds to the top-level object schema.
 	ResponseSchema struct {
-		NarratorText      string       `json:"narrator_text"`
-		EventPlan         []string     `json:"event_plan"`
-		EventLongHistory  []string     `json:"event_long_history"`
-		EventShortHistory []string     `json:"event_short_history"`
-		EntityData        []EntityData `json:"entity_data"`
-		RollDice          *RollDice    `json:"roll_dice"`
+		NarratorText      string          `json:"narrator_text"`
+		Place             string          `json:"place"`
+		EventPlan         []string        `json:"event_plan"`
+		EventLongHistory  []string        `json:"event_long_history"`
+		EventShortHistory []string        `json:"event_short_history"`
+		EntityData        []EntityData    `json:"entity_data"`
+		RollDice          *RollDice       `json:"roll_dice"`
+		NarratorMarkup    string          `json:"narrator_markup"`
+		Speech            []SpeechSegment `json:"speech"`
+		DramaticMoment    bool            `json:"dramatic_moment"`
+		Scene             string          `json:"scene"`
+
+		// illustrate is set if the response changes the place or is dramatic.
+		illustrate bool
 	}
 
 	PromptDataSchema struct {
@@ -50,50 +59,44 @@ type (
 		PlayerID string `json:"player,omitempty"`
 		Message  string `json:"message"`
 		Audio    string `json:"audio"`
+		// Markup is Message with narration markup for the TTS.
+		Markup string `json:"markup,omitempty"`
+		// Segments are set if the message is spoken by multiple voices.
+		// The audio of each segment is in AudioSegments.
+		Segments      []SpeechSegment `json:"segments,omitempty"`
+		AudioSegments []string        `json:"audio_segments,omitempty"`
+		// Scene is set if the message should be illustrated with Image.
+		Scene string `json:"scene,omitempty"`
+		Image string `json:"image,omitempty"`
+		// Roll is the dice roll the message asked for, set once it is rolled.
+		Roll *DiceRoll `json:"roll,omitempty"`
 	}
-)
 
-var (
-	//go:embed system.txt
-	systemInstructionTxt string
-	//go:embed start.txt
-	startPromptTxt string
-)
-
-var (
-	topP        float32 = 0.5
-	topK        float32 = 5
-	temperature float32 = 0.7
-	// frequencyPenalty float32                      = 0.5
-	// presencePenalty  float32                      = 0.5
-	// mainConfig       *genai.GenerateContentConfig = &genai.GenerateContentConfig{
-	// 	TopP:             &topP,
-	// 	TopK:             &topK,
-	// 	Temperature:      &temperature,
-	// 	FrequencyPenalty: &frequencyPenalty,
-	// 	PresencePenalty:  &presencePenalty,
-	// 	ResponseMIMEType: "application/json",
-	// 	ResponseSchema:   llmResponseGenaiSchema,
-	// }
-	thinkingConfig *genai.GenerateContentConfig = &genai.GenerateContentConfig{
-		TopP:             &topP,
-		TopK:             &topK,
-		Temperature:      &temperature,
-		ResponseMIMEType: "application/json",
-		ResponseSchema:   llmResponseGenaiSchema,
-		SystemInstruction: &genai.Content{
-			Parts: []*genai.Part{{Text: systemInstructionTxt}},
-			Role:  "model",
-		},
+	// DiceRoll is a roll a player has to make, the result is rolled in
+	// advance.
+	DiceRoll struct {
+		Difficulty uint8 `json:"difficulty"`
+		Result     uint8 `json:"result"`
 	}
 )
 
-const maxRecentChatHistory = 10
+// responseSchemas are the schemas of ResponseSchema by language.
+var responseSchemas = make(map[string]*genai.Schema)
 
-func (llm *AI) Data() []*genai.Content {
-	sb := strings.Builder{}
-	sb.WriteString("Aktuelle Spieldaten: ")
+func init() {
+	for _, l := range locale.List() {
+		responseSchemas[l.Code] = newResponseSchema(l.Schema)
+	}
+}
 
+// Locale returns the texts of the campaign language.
+func (ai *AI) Locale() *locale.Locale {
+	return locale.GetOrDefault(ai.Language)
+}
+
+// Data renders the compaction prompt with the memory of the AI, it is sent
+// with every request.
+func (llm *AI) Data() ([]*genai.Content, error) {
 	data := PromptDataSchema{
 		llm.EventPlan,
 		llm.EventLongHistory,
@@ -101,25 +104,68 @@ func (llm *AI) Data() []*genai.Content {
 		llm.EntityData,
 		nil,
 	}
-	if len(llm.ChatHistory) > maxRecentChatHistory {
-		data.RecentChatHistory = llm.ChatHistory[len(llm.ChatHistory)-maxRecentChatHistory:]
-	} else {
-		data.RecentChatHistory = llm.ChatHistory
+	omitted := 0
+	if recent := llm.cfg.LLM.RecentChatHistory; len(llm.ChatHistory) > recent {
+		omitted = len(llm.ChatHistory) - recent
+	}
+	data.RecentChatHistory = llm.ChatHistory[omitted:]
+	// json.Marshal escapes < and >, so the text of the players can't close
+	// the tag the compaction prompt puts the data in
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
 	}
-	je := json.NewEncoder(&sb)
-	_ = je.Encode(data)
 
-	return genai.Text(sb.String())
+	text, err := llm.Prompt(prompts.Compaction, prompts.CompactionData{Data: string(b), OmittedMessages: omitted})
+	if err != nil {
+		return nil, err
+	}
+	return genai.Text(text), nil
 }
 
-func (llm *AI) Start(scenario string) ResponseSchema {
-	fmt.Println("Starting scenario:", scenario)
-	return llm.Text(true, llm.Data(), genai.Text(fmt.Sprintf(startPromptTxt, scenario)))
+// Prompt renders the prompt template name in the language of the campaign.
+func (ai *AI) Prompt(name string, data any) (string, error) {
+	set := ai.prompts
+	if set == nil {
+		set = prompts.Current()
+	}
+	return set.Render(ai.Locale().Code, name, data)
 }
 
-func (llm *AI) Continue(text string) ResponseSchema {
-	fmt.Println("Continuing:", text)
-	return llm.Text(false, llm.Data(), genai.Text(text))
+// SetLocker makes Generate unlock l while it waits for the LLM and lock it
+// again before the response is applied, nil keeps it locked. l guards the AI
+// and must be locked when Generate is called; the AI must not be changed while
+// it is unlocked.
+func (ai *AI) SetLocker(l sync.Locker) {
+	ai.locker = l
+}
+
+// unlocked runs fn with the locker of the AI released, see SetLocker.
+func (ai *AI) unlocked(fn func()) {
+	if ai.locker == nil {
+		fn()
+		return
+	}
+	ai.locker.Unlock()
+	defer ai.locker.Lock()
+	fn()
+}
+
+// SetPrompts makes the AI use other prompt templates than the current ones of
+// the server, nil switches back to them.
+func (ai *AI) SetPrompts(set *prompts.Set) {
+	ai.prompts = set
+}
+
+// Start starts the campaign with the rendered start prompt.
+func (llm *AI) Start(ctx context.Context, prompt string) ResponseSchema {
+	slog.DebugContext(ctx, "starting scenario", "prompt", prompt)
+	return llm.Text(ctx, true, genai.Text(prompt))
+}
+
+func (llm *AI) Continue(ctx context.Context, text string) ResponseSchema {
+	slog.DebugContext(ctx, "continuing", "prompt", text)
+	return llm.Text(ctx, false, genai.Text(text))
 }
 
 func flatten[T any](slice [][]T) []T {
@@ -134,21 +180,95 @@ func flatten[T any](slice [][]T) []T {
 	return flattened
 }
 
-func (ai *AI) Text(thinking bool, parts ...[]*genai.Content) ResponseSchema {
-	var model string
-	config := thinkingConfig
-	if thinking {
-		model = thinkingModel
-		// config = thinkingConfig
-	} else {
-		model = mainModel
-		// config = mainConfig
-	}
-	resp, err := ai.llmClient.Models.GenerateContent(ai.ctx, model, flatten(parts), config)
+// Text sends the game data and the prompt parts to the LLM and applies the
+// response to the memory of the AI. Errors are told to the players by the
+// narrator.
+func (ai *AI) Text(ctx context.Context, thinking bool, parts ...[]*genai.Content) ResponseSchema {
+	resp, err := ai.Generate(ctx, thinking, parts...)
 	if err != nil {
-		fmt.Println("Error generating content:", err)
 		return ResponseSchema{NarratorText: "Error generating content: " + err.Error()}
 	}
+	return resp
+}
+
+// Generate is Text, but returns the errors. A response the safety filter
+// rejects is generated again, see safetyFilter, and replaced by a notice if
+// it is still rejected after the retries of the config.
+func (ai *AI) Generate(ctx context.Context, thinking bool, parts ...[]*genai.Content) (ResponseSchema, error) {
+	// a failed or replaced response adds nothing to revert
+	ai.LastResponse = nil
+	data, err := ai.Data()
+	if err != nil {
+		slog.ErrorContext(ctx, "error rendering game data", "err", err)
+		return ResponseSchema{}, err
+	}
+	model, config, err := ai.generateConfig(thinking)
+	if err != nil {
+		slog.ErrorContext(ctx, "error rendering system prompt", "err", err)
+		return ResponseSchema{}, err
+	}
+	contents := flatten(append([][]*genai.Content{data}, parts...))
+
+	for retry := 0; ; retry++ {
+		var (
+			respData             ResponseSchema
+			text, reason, filter string
+		)
+		ai.unlocked(func() {
+			respData, text, err = ai.generate(ctx, model, contents, config)
+			if err == nil {
+				reason, filter = ai.safetyFilter(ctx, respData)
+			}
+		})
+		if err != nil {
+			return ResponseSchema{}, err
+		}
+		if reason == "" {
+			respData.illustrate = respData.DramaticMoment || (respData.Place != "" && respData.Place != ai.Place)
+			ai.applyResponse(respData)
+			return respData, nil
+		}
+
+		if retry == ai.cfg.Safety.Retries {
+			metrics.SafetyFiltered.WithLabelValues(filter, "replaced").Inc()
+			slog.WarnContext(ctx, "narrator text replaced by the safety filter", "filter", filter, "reason", reason)
+			return ai.filteredResponse(), nil
+		}
+		metrics.SafetyFiltered.WithLabelValues(filter, "retried").Inc()
+		slog.InfoContext(ctx, "narrator text rejected by the safety filter", "filter", filter, "reason", reason, "retry", retry+1)
+		rewrite, err := ai.Prompt(prompts.SafetyRewrite, prompts.SafetyRewriteData{Reason: reason})
+		if err != nil {
+			return ResponseSchema{}, err
+		}
+		contents = append(contents, genai.NewContentFromText(text, genai.RoleModel))
+		contents = append(contents, genai.Text(rewrite)...)
+	}
+}
+
+// generate sends one request to the LLM and returns the decoded response and
+// its raw text.
+func (ai *AI) generate(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (ResponseSchema, string, error) {
+	start := time.Now()
+	resp, err := ai.llmClient.Models.GenerateContent(ctx, model, contents, config)
+	latency := time.Since(start)
+	metrics.LLMDuration.WithLabelValues(model).Observe(latency.Seconds())
+	if err != nil {
+		metrics.LLMErrors.WithLabelValues(model).Inc()
+		slog.ErrorContext(ctx, "error generating content", "model", model, "latency", latency, "err", err)
+		return ResponseSchema{}, "", err
+	}
+	if resp.UsageMetadata != nil {
+		metrics.LLMTokens.WithLabelValues(model, "prompt").Add(float64(resp.UsageMetadata.PromptTokenCount))
+		metrics.LLMTokens.WithLabelValues(model, "response").Add(float64(resp.UsageMetadata.CandidatesTokenCount))
+		metrics.LLMTokens.WithLabelValues(model, "thoughts").Add(float64(resp.UsageMetadata.ThoughtsTokenCount))
+		slog.InfoContext(ctx, "generated content", "model", model, "latency", latency,
+			"prompt_tokens", resp.UsageMetadata.PromptTokenCount,
+			"response_tokens", resp.UsageMetadata.CandidatesTokenCount,
+			"thoughts_tokens", resp.UsageMetadata.ThoughtsTokenCount,
+		)
+	} else {
+		slog.InfoContext(ctx, "generated content", "model", model, "latency", latency)
+	}
 
 	sb := strings.Builder{}
 	for _, candidate := range resp.Candidates {
@@ -159,22 +279,98 @@ func (ai *AI) Text(thinking bool, parts ...[]*genai.Content) ResponseSchema {
 
 	jd := json.NewDecoder(strings.NewReader(sb.String()))
 	respData := ResponseSchema{}
-	_ = jd.Decode(&respData)
+	if err := jd.Decode(&respData); err != nil {
+		slog.WarnContext(ctx, "failed to decode response", "model", model, "err", err)
+	}
 
-	ai.applyResponse(respData)
+	return respData, sb.String(), nil
+}
 
-	return respData
+// ChatMessage creates the chat message of the narrator for the response.
+// Markup that leaked into narrator_text is removed for display.
+func (rs *ResponseSchema) ChatMessage() ChatMessage {
+	m := ChatMessage{Role: "model", Message: StripMarkup(rs.NarratorText), Segments: speechSegments(rs.Speech)}
+	if HasMarkup(rs.NarratorMarkup) {
+		m.Markup = rs.NarratorMarkup
+	}
+	if rs.illustrate {
+		m.Scene = cmp.Or(rs.Scene, rs.Place)
+	}
+	return m
+}
+
+// Spoken returns the text of the message for the TTS.
+func (m *ChatMessage) Spoken() string {
+	if m.Markup != "" {
+		return m.Markup
+	}
+	return m.Message
 }
 
 func appendTime(s string) string {
 	return fmt.Sprintf("%s %s", time.Now().Format(time.RFC3339), s)
 }
 
+// MemoryMark is the size of the memory before a response was applied to it.
+// The memory only grows, so cutting it back to the mark reverts the response.
+type MemoryMark struct {
+	Place             string `json:"place"`
+	EventPlan         int    `json:"event_plan"`
+	EventLongHistory  int    `json:"event_long_history"`
+	EventShortHistory int    `json:"event_short_history"`
+	// EntityData are the numbers of entries of the entities the response
+	// wrote to.
+	EntityData map[string]int `json:"entity_data,omitempty"`
+}
+
+// RevertLastResponse removes what the last response added to the memory,
+// e.g. after the players rejected it with the X-card. The chat history is
+// left to the caller.
+func (ai *AI) RevertLastResponse() {
+	mark := ai.LastResponse
+	if mark == nil {
+		return
+	}
+	ai.LastResponse = nil
+	ai.Place = mark.Place
+	ai.EventPlan = ai.EventPlan[:min(mark.EventPlan, len(ai.EventPlan))]
+	ai.EventLongHistory = ai.EventLongHistory[:min(mark.EventLongHistory, len(ai.EventLongHistory))]
+	ai.EventShortHistory = ai.EventShortHistory[:min(mark.EventShortHistory, len(ai.EventShortHistory))]
+	for name, n := range mark.EntityData {
+		if n == 0 {
+			delete(ai.EntityData, name)
+			continue
+		}
+		if data, ok := ai.EntityData[name]; ok {
+			ai.EntityData[name] = data[:min(n, len(data))]
+		}
+	}
+}
+
 func (llm *AI) applyResponse(resp ResponseSchema) {
+	mark := &MemoryMark{
+		Place:             llm.Place,
+		EventPlan:         len(llm.EventPlan),
+		EventLongHistory:  len(llm.EventLongHistory),
+		EventShortHistory: len(llm.EventShortHistory),
+	}
+	for _, entityData := range resp.EntityData {
+		if mark.EntityData == nil {
+			mark.EntityData = make(map[string]int)
+		}
+		if _, ok := mark.EntityData[entityData.EntityName]; !ok {
+			mark.EntityData[entityData.EntityName] = len(llm.EntityData[entityData.EntityName])
+		}
+	}
+	llm.LastResponse = mark
+
+	if resp.Place != "" {
+		llm.Place = resp.Place
+	}
 	if resp.EntityData != nil {
 		for i, entityData := range resp.EntityData {
 			if llm.EntityData == nil {
-				fmt.Sprintln("ai.EntityData should not be nil at this point")
+				slog.Error("ai.EntityData should not be nil at this point")
 				os.Exit(1)
 				llm.EntityData = make(map[string][]string)
 			}
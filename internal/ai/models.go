@@ -0,0 +1,192 @@
+package ai
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/prompts"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// modelListTTL is how long the models of the provider are cached, they are
+// listed for every lobby and every validation.
+const modelListTTL = time.Hour
+
+// maxThinkingBudget is the largest budget of the Gemini 2.5 models.
+const maxThinkingBudget = 32768
+
+var ErrInvalidModelSettings = errors.New("invalid model settings")
+
+type (
+	// Model is a model the provider exposes.
+	Model struct {
+		// Name is the id used in requests, e.g. gemini-2.5-flash.
+		Name             string   `json:"name"`
+		DisplayName      string   `json:"display_name"`
+		InputTokenLimit  int32    `json:"input_token_limit"`
+		OutputTokenLimit int32    `json:"output_token_limit"`
+		SupportedActions []string `json:"supported_actions"`
+	}
+
+	// ModelSettings are the model and sampling parameters of a game, chosen
+	// in the lobby. Empty fields use the llm settings of the config.
+	ModelSettings struct {
+		// Model writes the turns of the campaign.
+		Model string `json:"model,omitempty"`
+		// ThinkingModel writes the start of the campaign with the story plan.
+		ThinkingModel string   `json:"thinking_model,omitempty"`
+		Temperature   *float32 `json:"temperature,omitempty"`
+		// ThinkingBudget limits the tokens the thinking model thinks with,
+		// -1 lets the model decide and 0 turns thinking off.
+		ThinkingBudget *int32 `json:"thinking_budget,omitempty"`
+		// MaxOutputTokens limits every response, 0 is the limit of the model.
+		MaxOutputTokens int32 `json:"max_output_tokens,omitempty"`
+	}
+)
+
+var (
+	modelList        []Model
+	modelListExpires time.Time
+	modelListMut     sync.Mutex
+)
+
+// ListModels returns all models the provider exposes for the API key of cfg.
+func ListModels(ctx context.Context, cfg *config.Config) ([]Model, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  cfg.GoogleAPIKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create gemini client"), err)
+	}
+
+	var models []Model
+	page, err := client.Models.List(ctx, nil)
+	for err == nil {
+		for _, m := range page.Items {
+			models = append(models, Model{
+				Name:             strings.TrimPrefix(m.Name, "models/"),
+				DisplayName:      m.DisplayName,
+				InputTokenLimit:  m.InputTokenLimit,
+				OutputTokenLimit: m.OutputTokenLimit,
+				SupportedActions: m.SupportedActions,
+			})
+		}
+		page, err = page.Next(ctx)
+	}
+	if !errors.Is(err, genai.ErrPageDone) {
+		return nil, err
+	}
+	return models, nil
+}
+
+// TextModels returns the models that can play a campaign, that is the ones
+// that generate content. The list is cached for modelListTTL.
+func TextModels(ctx context.Context, cfg *config.Config) ([]Model, error) {
+	modelListMut.Lock()
+	defer modelListMut.Unlock()
+
+	if modelList != nil && time.Now().Before(modelListExpires) {
+		return modelList, nil
+	}
+	all, err := ListModels(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Model, 0, len(all))
+	for _, m := range all {
+		if slices.Contains(m.SupportedActions, "generateContent") {
+			list = append(list, m)
+		}
+	}
+	modelList = list
+	modelListExpires = time.Now().Add(modelListTTL)
+	return modelList, nil
+}
+
+// IsZero reports whether all settings fall back to the config.
+func (s ModelSettings) IsZero() bool {
+	return s.Model == "" && s.ThinkingModel == "" && s.Temperature == nil && s.ThinkingBudget == nil && s.MaxOutputTokens == 0
+}
+
+// ValidateModelSettings checks the settings against the limits of the models
+// the provider exposes. The models are only listed if a setting needs them.
+func ValidateModelSettings(ctx context.Context, cfg *config.Config, s ModelSettings) error {
+	var errs []error
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		errs = append(errs, fmt.Errorf("temperature %g is not between 0 and 2", *s.Temperature))
+	}
+	if s.ThinkingBudget != nil && (*s.ThinkingBudget < -1 || *s.ThinkingBudget > maxThinkingBudget) {
+		errs = append(errs, fmt.Errorf("thinking budget %d is not between -1 and %d", *s.ThinkingBudget, maxThinkingBudget))
+	}
+	if s.MaxOutputTokens < 0 {
+		errs = append(errs, fmt.Errorf("max output tokens %d is negative", s.MaxOutputTokens))
+	}
+
+	if s.Model != "" || s.ThinkingModel != "" || s.MaxOutputTokens > 0 {
+		models, err := TextModels(ctx, cfg)
+		if err != nil {
+			return errors.Join(errors.New("failed to list models"), err)
+		}
+		for _, name := range []string{cmp.Or(s.Model, cfg.LLM.Model), cmp.Or(s.ThinkingModel, cfg.LLM.ThinkingModel)} {
+			i := slices.IndexFunc(models, func(m Model) bool { return m.Name == name })
+			if i < 0 {
+				errs = append(errs, fmt.Errorf("model %q is not available", name))
+				continue
+			}
+			if limit := models[i].OutputTokenLimit; limit > 0 && s.MaxOutputTokens > limit {
+				errs = append(errs, fmt.Errorf("max output tokens %d exceed the limit %d of %s", s.MaxOutputTokens, limit, name))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(append([]error{ErrInvalidModelSettings}, errs...)...)
+	}
+	return nil
+}
+
+// generateConfig returns the model and config for a request of the AI, the
+// thinking model with the thinking budget starts the campaign.
+func (ai *AI) generateConfig(thinking bool) (string, *genai.GenerateContentConfig, error) {
+	l := ai.Locale()
+	s := ai.Models
+	system, err := ai.Prompt(prompts.System, prompts.SystemData{Language: l.Name, Boundaries: ai.Boundaries.prompt()})
+	if err != nil {
+		return "", nil, err
+	}
+
+	temperature := ai.cfg.LLM.Temperature
+	if s.Temperature != nil {
+		temperature = *s.Temperature
+	}
+	config := &genai.GenerateContentConfig{
+		TopP:             &ai.cfg.LLM.TopP,
+		TopK:             &ai.cfg.LLM.TopK,
+		Temperature:      &temperature,
+		MaxOutputTokens:  cmp.Or(s.MaxOutputTokens, int32(ai.cfg.LLM.MaxOutputTokens)),
+		ResponseMIMEType: "application/json",
+		ResponseSchema:   responseSchemas[l.Code],
+		SystemInstruction: &genai.Content{
+			Parts: []*genai.Part{{Text: system}},
+			Role:  "model",
+		},
+	}
+	if !thinking {
+		return cmp.Or(s.Model, ai.cfg.LLM.Model), config, nil
+	}
+
+	budget := int32(ai.cfg.LLM.ThinkingBudget)
+	if s.ThinkingBudget != nil {
+		budget = *s.ThinkingBudget
+	}
+	config.ThinkingConfig = &genai.ThinkingConfig{ThinkingBudget: &budget}
+	return cmp.Or(s.ThinkingModel, ai.cfg.LLM.ThinkingModel), config, nil
+}
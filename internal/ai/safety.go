@@ -0,0 +1,249 @@
+package ai
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/prompts"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+const (
+	// maxBoundaries is the number of lines and of veils a table can set.
+	maxBoundaries = 20
+	// maxBoundaryLength is the length of a line or veil in runes.
+	maxBoundaryLength = 200
+	// xCardExcerptLength is the length of the excerpt of a rejected message
+	// that is remembered if the X-card names no topic.
+	xCardExcerptLength = 300
+)
+
+var ErrInvalidBoundaries = errors.New("invalid lines or veils")
+
+// Boundaries are the lines and veils the players of a table agreed on. They
+// are part of the system prompt and every narrator text is checked against
+// them, see prompts.Boundaries.
+type Boundaries struct {
+	// Lines are topics that must never appear.
+	Lines []string `json:"lines,omitempty"`
+	// Veils are topics that may happen, but only off-screen.
+	Veils []string `json:"veils,omitempty"`
+	// XCarded are excerpts of messages the players rejected with the X-card
+	// without naming a topic.
+	XCarded []string `json:"x_carded,omitempty"`
+}
+
+// safetyCheckSchema is the response of the classifier.
+var safetyCheckSchema = &genai.Schema{
+	Type:     genai.TypeObject,
+	Nullable: falsePtr,
+	Required: []string{"violation", "topic"},
+	Properties: map[string]*genai.Schema{
+		"violation": {Type: genai.TypeBoolean, Nullable: falsePtr},
+		"topic":     {Type: genai.TypeString, Nullable: falsePtr},
+	},
+}
+
+type safetyCheck struct {
+	Violation bool   `json:"violation"`
+	Topic     string `json:"topic"`
+}
+
+// Normalize returns the boundaries with trimmed entries and without empty
+// ones or duplicates, or ErrInvalidBoundaries if there are too many or too
+// long entries. XCarded is dropped, only XCard adds to it.
+func (b Boundaries) Normalize() (Boundaries, error) {
+	var errs []error
+	normalize := func(name string, list []string) []string {
+		var normalized []string
+		for _, entry := range list {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || slices.Contains(normalized, entry) {
+				continue
+			}
+			if utf8.RuneCountInString(entry) > maxBoundaryLength {
+				errs = append(errs, fmt.Errorf("%s longer than %d characters", name, maxBoundaryLength))
+				continue
+			}
+			normalized = append(normalized, entry)
+		}
+		if len(normalized) > maxBoundaries {
+			errs = append(errs, fmt.Errorf("more than %d %ss", maxBoundaries, name))
+		}
+		return normalized
+	}
+	b.Lines = normalize("line", b.Lines)
+	b.Veils = normalize("veil", b.Veils)
+	b.XCarded = nil
+	if len(errs) > 0 {
+		return Boundaries{}, errors.Join(append([]error{ErrInvalidBoundaries}, errs...)...)
+	}
+	return b, nil
+}
+
+// NormalizePlayed is Normalize for the boundaries of a campaign that is
+// already played, read from outside the server, e.g. from an archive.
+// XCarded is kept if it is within the limits of XCard.
+func (b Boundaries) NormalizePlayed() (Boundaries, error) {
+	normalized, err := b.Normalize()
+	if err != nil {
+		return Boundaries{}, err
+	}
+	if len(b.XCarded) > maxBoundaries {
+		return Boundaries{}, fmt.Errorf("%w: more than %d X-card excerpts", ErrInvalidBoundaries, maxBoundaries)
+	}
+	for _, excerpt := range b.XCarded {
+		if utf8.RuneCountInString(excerpt) > xCardExcerptLength {
+			return Boundaries{}, fmt.Errorf("%w: X-card excerpt longer than %d characters", ErrInvalidBoundaries, xCardExcerptLength)
+		}
+	}
+	normalized.XCarded = b.XCarded
+	return normalized, nil
+}
+
+// IsZero reports whether the table set no boundaries.
+func (b Boundaries) IsZero() bool {
+	return len(b.Lines) == 0 && len(b.Veils) == 0 && len(b.XCarded) == 0
+}
+
+func (b Boundaries) prompt() prompts.Boundaries {
+	return prompts.Boundaries{Lines: b.Lines, Veils: b.Veils, XCarded: b.XCarded}
+}
+
+// XCard remembers that the players rejected message. A topic becomes a line,
+// without one an excerpt of the message is avoided from then on. The limits
+// of Normalize apply: a topic is cut to the length of a line, and once the
+// lines are full it is remembered like an excerpt. Of the excerpts only the
+// latest maxBoundaries are kept.
+func (ai *AI) XCard(topic string, message string) {
+	topic = strings.TrimSpace(topic)
+	if topic != "" {
+		topic = truncate(topic, maxBoundaryLength)
+		if slices.Contains(ai.Boundaries.Lines, topic) {
+			return
+		}
+		if len(ai.Boundaries.Lines) < maxBoundaries {
+			ai.Boundaries.Lines = append(ai.Boundaries.Lines, topic)
+			return
+		}
+		message = topic
+	}
+	ai.Boundaries.XCarded = append(ai.Boundaries.XCarded, truncate(strings.TrimSpace(message), xCardExcerptLength))
+	if n := len(ai.Boundaries.XCarded); n > maxBoundaries {
+		ai.Boundaries.XCarded = slices.Clone(ai.Boundaries.XCarded[n-maxBoundaries:])
+	}
+}
+
+// truncate cuts s to at most length runes, the last one an ellipsis that
+// marks the cut.
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(append(runes[:length-1], '…'))
+}
+
+// safetyFilter returns why a response crosses the boundaries of the table
+// and the filter that noticed it, the reason is empty if the response passes.
+// Every text the players see or hear is checked, see ResponseSchema.texts.
+// The keywords of the config and the lines are looked for at the start of
+// words, the classifier also understands the veils. An error of the
+// classifier lets the response pass.
+func (ai *AI) safetyFilter(ctx context.Context, rs ResponseSchema) (string, string) {
+	texts := rs.texts()
+	for _, keyword := range slices.Concat(ai.cfg.Safety.Keywords, ai.Boundaries.Lines) {
+		for _, text := range texts {
+			if containsWord(text, keyword) {
+				return keyword, "keyword"
+			}
+		}
+	}
+
+	model := ai.cfg.Safety.ClassifierModel
+	if model == "" || ai.Boundaries.IsZero() {
+		return "", ""
+	}
+	// the classifier is asked once for all texts
+	text := strings.Join(texts, "\n\n")
+	prompt, err := ai.Prompt(prompts.SafetyCheck, prompts.SafetyCheckData{Boundaries: ai.Boundaries.prompt(), Text: text})
+	if err != nil {
+		slog.ErrorContext(ctx, "error rendering safety check", "err", err)
+		return "", ""
+	}
+	start := time.Now()
+	resp, err := ai.llmClient.Models.GenerateContent(ctx, model, genai.Text(prompt), &genai.GenerateContentConfig{
+		Temperature:      genai.Ptr[float32](0),
+		ResponseMIMEType: "application/json",
+		ResponseSchema:   safetyCheckSchema,
+	})
+	metrics.LLMDuration.WithLabelValues(model).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.LLMErrors.WithLabelValues(model).Inc()
+		slog.WarnContext(ctx, "error checking narrator text", "model", model, "err", err)
+		return "", ""
+	}
+	check := safetyCheck{}
+	if err := json.Unmarshal([]byte(resp.Text()), &check); err != nil {
+		slog.WarnContext(ctx, "failed to decode safety check", "model", model, "err", err)
+		return "", ""
+	}
+	if !check.Violation {
+		return "", ""
+	}
+	return cmp.Or(strings.TrimSpace(check.Topic), "?"), "classifier"
+}
+
+// texts returns the distinct, non-empty texts of the response the players
+// see or hear: the narrator text, its markup for the TTS and the speech
+// segments.
+func (rs *ResponseSchema) texts() []string {
+	texts := []string{rs.NarratorText, rs.NarratorMarkup}
+	for _, segment := range rs.Speech {
+		texts = append(texts, segment.Text)
+	}
+	var distinct []string
+	for _, text := range texts {
+		if text = strings.TrimSpace(text); text != "" && !slices.Contains(distinct, text) {
+			distinct = append(distinct, text)
+		}
+	}
+	return distinct
+}
+
+// containsWord reports whether a word of text starts with keyword, ignoring
+// case, so "spider" finds "Spiders" but "rat" doesn't find "pirate".
+func containsWord(text string, keyword string) bool {
+	text, keyword = strings.ToLower(text), strings.ToLower(strings.TrimSpace(keyword))
+	if keyword == "" {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], keyword)
+		if i < 0 {
+			return false
+		}
+		i += offset
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		if i == 0 || !unicode.IsLetter(before) && !unicode.IsDigit(before) {
+			return true
+		}
+		offset = i + len(keyword)
+	}
+}
+
+// filteredResponse replaces a response the filter rejected every time. It
+// changes nothing in the memory, so the rejected story is forgotten.
+func (ai *AI) filteredResponse() ResponseSchema {
+	return ResponseSchema{NarratorText: ai.Locale().Safety.Filtered}
+}
@@ -0,0 +1,118 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/metrics"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcriber is a speech-to-text backend for recorded player input.
+type Transcriber interface {
+	// Name identifies the backend, e.g. in logs and metrics.
+	Name() string
+	// Transcribe returns the text spoken in audio, mimeType is the format
+	// the browser recorded in (e.g. audio/webm) and language is the language
+	// of the campaign the player speaks.
+	Transcribe(ctx context.Context, audio []byte, mimeType string, language *locale.Locale) (string, error)
+	Close() error
+}
+
+type transcriberFactory func(ctx context.Context, cfg *config.Config) (Transcriber, error)
+
+type transcriberBackend struct {
+	factory transcriberFactory
+	// enabled reports whether the backend is configured, nil is always.
+	enabled func(cfg *config.Config) bool
+}
+
+var (
+	ErrUnknownTranscriber = errors.New("unknown stt backend")
+	ErrEmptyTranscript    = errors.New("no speech recognized")
+
+	transcriberBackends = map[string]transcriberBackend{}
+
+	// transcriber is shared by all games and created on first use.
+	transcriber    Transcriber
+	transcriberMut sync.Mutex
+)
+
+// registerTranscriber makes an STT backend selectable by name if enabled
+// reports that it is configured.
+func registerTranscriber(name string, factory transcriberFactory, enabled func(cfg *config.Config) bool) {
+	transcriberBackends[name] = transcriberBackend{factory, enabled}
+}
+
+// Transcribers returns the names of all STT backends that can be used with cfg.
+func Transcribers(cfg *config.Config) []string {
+	names := make([]string, 0, len(transcriberBackends))
+	for name, b := range transcriberBackends {
+		if b.enabled == nil || b.enabled(cfg) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func getTranscriber(ctx context.Context, cfg *config.Config) (Transcriber, error) {
+	transcriberMut.Lock()
+	defer transcriberMut.Unlock()
+
+	if transcriber != nil {
+		return transcriber, nil
+	}
+	if !slices.Contains(Transcribers(cfg), cfg.STT.Backend) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTranscriber, cfg.STT.Backend)
+	}
+	t, err := transcriberBackends[cfg.STT.Backend].factory(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	transcriber = t
+	return transcriber, nil
+}
+
+// Transcribe turns recorded player input into text with the STT backend of
+// cfg.
+func Transcribe(ctx context.Context, cfg *config.Config, audio []byte, mimeType string, language *locale.Locale) (string, error) {
+	t, err := getTranscriber(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+	name := t.Name()
+
+	start := time.Now()
+	text, err := t.Transcribe(ctx, audio, mimeType, language)
+	latency := time.Since(start)
+	metrics.STTDuration.WithLabelValues(name).Observe(latency.Seconds())
+	if err != nil {
+		metrics.STTErrors.WithLabelValues(name).Inc()
+		return "", errors.Join(errors.New("failed to transcribe audio"), err)
+	}
+	slog.InfoContext(ctx, "transcribed audio", "backend", name, "latency", latency, "bytes", len(audio), "transcript_length", len(text))
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", ErrEmptyTranscript
+	}
+	return text, nil
+}
+
+// CloseTranscriber closes the shared STT backend.
+func CloseTranscriber() {
+	transcriberMut.Lock()
+	defer transcriberMut.Unlock()
+
+	if transcriber != nil {
+		_ = transcriber.Close()
+		transcriber = nil
+	}
+}
@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"gameslabor/internal/config"
+	"gameslabor/internal/locale"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+func init() {
+	registerTranscriber("gemini", newGeminiTranscriber, nil)
+}
+
+// geminiTranscriber sends the recording to Gemini, which understands audio.
+type geminiTranscriber struct {
+	client *genai.Client
+	model  string
+}
+
+func newGeminiTranscriber(ctx context.Context, cfg *config.Config) (Transcriber, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  cfg.GoogleAPIKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create gemini client"), err)
+	}
+	return &geminiTranscriber{client: client, model: cfg.LLM.Model}, nil
+}
+
+func (t *geminiTranscriber) Name() string {
+	return "gemini"
+}
+
+func (t *geminiTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType string, language *locale.Locale) (string, error) {
+	// browsers add the codec, e.g. audio/webm;codecs=opus
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	contents := []*genai.Content{{
+		Role: "user",
+		Parts: []*genai.Part{
+			{Text: language.Transcribe},
+			{InlineData: &genai.Blob{MIMEType: mimeType, Data: audio}},
+		},
+	}}
+	resp, err := t.client.Models.GenerateContent(ctx, t.model, contents, nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text(), nil
+}
+
+func (t *geminiTranscriber) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/locale"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	registerTranscriber("whisper", newWhisperTranscriber, func(cfg *config.Config) bool {
+		return cfg.STT.WhisperModel != ""
+	})
+}
+
+// whisperTranscriber runs whisper.cpp locally. whisper.cpp only reads 16 kHz
+// WAV files, so the recording is converted with ffmpeg first.
+type whisperTranscriber struct {
+	bin   string
+	model string
+}
+
+func newWhisperTranscriber(ctx context.Context, cfg *config.Config) (Transcriber, error) {
+	return &whisperTranscriber{bin: cfg.STT.WhisperBin, model: cfg.STT.WhisperModel}, nil
+}
+
+func (t *whisperTranscriber) Name() string {
+	return "whisper"
+}
+
+func (t *whisperTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType string, language *locale.Locale) (string, error) {
+	dir, err := os.MkdirTemp("", "gameslabor-stt")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	wav := filepath.Join(dir, "input.wav")
+	convert := exec.CommandContext(ctx, "ffmpeg", "-loglevel", "error", "-i", "-", "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav)
+	convert.Stdin = bytes.NewReader(audio)
+	if err := run(convert); err != nil {
+		return "", fmt.Errorf("converting audio failed: %w", err)
+	}
+
+	whisper := exec.CommandContext(ctx, t.bin, "-m", t.model, "-l", language.Code, "--no-timestamps", "--no-prints", "-f", wav)
+	stdout := &bytes.Buffer{}
+	whisper.Stdout = stdout
+	if err := run(whisper); err != nil {
+		return "", fmt.Errorf("whisper failed: %w", err)
+	}
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
+
+func (t *whisperTranscriber) Close() error {
+	return nil
+}
+
+// run runs cmd and adds its stderr to the error.
+func run(cmd *exec.Cmd) error {
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -2,38 +2,283 @@ package ai
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/metrics"
+	"html"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Speaker is a text-to-speech backend that produces OGG audio.
+type Speaker interface {
+	// Name identifies the backend, e.g. in logs and metrics.
+	Name() string
+	// Voices lists the voices of the backend. The first one is the narrator.
+	Voices() []string
+	Synthesize(ctx context.Context, text string, voice string) ([]byte, error)
+	Close() error
+}
+
+// SSMLSpeaker is implemented by backends that can synthesize SSML.
+// SynthesizeSSML returns ErrSSMLUnsupported if the voice can't speak SSML.
+type SSMLSpeaker interface {
+	SynthesizeSSML(ctx context.Context, ssml string, voice string) ([]byte, error)
+}
 
-	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+// speakerFactory creates a backend that speaks the given language.
+type speakerFactory func(ctx context.Context, cfg *config.Config, language *locale.Locale) (Speaker, error)
+
+type speakerBackend struct {
+	factory speakerFactory
+	// enabled reports whether the backend is configured, nil is always.
+	enabled func(cfg *config.Config) bool
+}
+
+var (
+	ErrUnknownSpeaker  = errors.New("unknown tts backend")
+	ErrSSMLUnsupported = errors.New("ssml is not supported")
+
+	speakerBackends = map[string]speakerBackend{}
 )
 
+// registerSpeaker makes a TTS backend selectable by name if enabled reports
+// that it is configured.
+func registerSpeaker(name string, factory speakerFactory, enabled func(cfg *config.Config) bool) {
+	speakerBackends[name] = speakerBackend{factory, enabled}
+}
+
+// Speakers returns the names of all TTS backends that can be used with cfg.
+func Speakers(cfg *config.Config) []string {
+	names := make([]string, 0, len(speakerBackends))
+	for name := range speakerBackends {
+		if HasSpeaker(cfg, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func HasSpeaker(cfg *config.Config, name string) bool {
+	b, ok := speakerBackends[name]
+	return ok && (b.enabled == nil || b.enabled(cfg))
+}
+
+func newSpeaker(ctx context.Context, cfg *config.Config, name string, language *locale.Locale) (Speaker, error) {
+	if name == "" {
+		name = cfg.TTS.Backend
+	}
+	if !HasSpeaker(cfg, name) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSpeaker, name)
+	}
+	return speakerBackends[name].factory(ctx, cfg, language)
+}
+
 func (llm *AI) Close() {
-	_ = llm.ttsClient.Close()
+	if llm.speaker != nil {
+		_ = llm.speaker.Close()
+		llm.speaker = nil
+	}
+	if llm.illustrator != nil {
+		_ = llm.illustrator.Close()
+		llm.illustrator = nil
+	}
 }
 
-var (
-	ttsVoice = &texttospeechpb.VoiceSelectionParams{
-		LanguageCode: "de-DE",
-		Name:         "de-DE-Chirp3-HD-Algenib",
+// TTS synthesizes text with voice, an empty voice is the narrator.
+// text may contain narration markup.
+// Identical requests are answered from a cache, so TTS is cheap to retry.
+// It is safe to call TTS concurrently, see Voice for how to get a voice.
+func (ai *AI) TTS(ctx context.Context, text string, voice string) (string, error) {
+	speaker := ai.speaker
+	if speaker == nil {
+		return "", errors.New("no tts backend connected")
 	}
-	ttsAudioConfig = &texttospeechpb.AudioConfig{
-		AudioEncoding: texttospeechpb.AudioEncoding_OGG_OPUS,
+	if voice == "" {
+		voice = speaker.Voices()[0]
 	}
-)
+	name := speaker.Name() + "/" + voice
 
-func (ai *AI) TTS(text string) (string, error) {
-	req := texttospeechpb.SynthesizeSpeechRequest{
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
-		},
-		Voice:       ttsVoice,
-		AudioConfig: ttsAudioConfig,
+	key := audioCacheKey(name, text)
+	if audio, ok := cachedAudio(ai.GameID, key); ok {
+		metrics.TTSCacheHits.Inc()
+		slog.DebugContext(ctx, "synthesized speech from cache", "voice", name)
+		return audio, nil
 	}
 
-	ctx := context.Background()
-	resp, err := ai.ttsClient.SynthesizeSpeech(ctx, &req)
+	if ai.AssetsFull() {
+		return "", ErrAssetQuotaExceeded
+	}
+	start := time.Now()
+	audio, err := synthesize(ctx, speaker, text, voice)
+	latency := time.Since(start)
+	metrics.TTSDuration.WithLabelValues(name).Observe(latency.Seconds())
 	if err != nil {
+		metrics.TTSErrors.WithLabelValues(name).Inc()
 		return "", errors.Join(errors.New("failed to synthesize speech"), err)
 	}
-	return saveOgg(resp.GetAudioContent())
+	metrics.TTSBytes.WithLabelValues(name).Add(float64(len(audio)))
+	slog.InfoContext(ctx, "synthesized speech", "voice", name, "latency", latency, "bytes", len(audio))
+	filename, err := ai.saveOgg(audio)
+	if err != nil {
+		return "", err
+	}
+	cacheAudio(ai.GameID, key, filename)
+	return filename, nil
+}
+
+// audioCacheSize is the number of files the cache remembers over all games.
+// The cache only saves retries, so it is simply cleared when it is full.
+const audioCacheSize = 4096
+
+var (
+	// audioCache are the synthesized files by game, every game has its own
+	// assets, and audioCacheKey.
+	audioCache    = make(map[string]map[string]string)
+	audioCacheLen int
+	audioCacheMut sync.Mutex
+)
+
+func audioCacheKey(voice string, text string) string {
+	h := sha256.New()
+	h.Write([]byte(voice))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedAudio returns the file synthesized earlier for key if it still exists.
+func cachedAudio(game string, key string) (string, bool) {
+	audioCacheMut.Lock()
+	defer audioCacheMut.Unlock()
+
+	filename, ok := audioCache[game][key]
+	if !ok {
+		return "", false
+	}
+	if !assets.Exists(filename) {
+		delete(audioCache[game], key)
+		audioCacheLen--
+		return "", false
+	}
+	return filename, true
+}
+
+func cacheAudio(game string, key string, filename string) {
+	audioCacheMut.Lock()
+	defer audioCacheMut.Unlock()
+
+	if audioCacheLen >= audioCacheSize {
+		clear(audioCache)
+		audioCacheLen = 0
+	}
+	if audioCache[game] == nil {
+		audioCache[game] = make(map[string]string)
+	}
+	if _, ok := audioCache[game][key]; !ok {
+		audioCacheLen++
+	}
+	audioCache[game][key] = filename
+}
+
+// forgetAudio removes the files of game from the cache, e.g. once it is
+// deleted.
+func forgetAudio(game string) {
+	audioCacheMut.Lock()
+	defer audioCacheMut.Unlock()
+
+	audioCacheLen -= len(audioCache[game])
+	delete(audioCache, game)
+}
+
+// synthesize uses SSML if the text has markup and the backend supports it.
+// Otherwise the markup is dropped and the plain text is spoken.
+func synthesize(ctx context.Context, speaker Speaker, text string, voice string) ([]byte, error) {
+	if ssmlSpeaker, ok := speaker.(SSMLSpeaker); ok && HasMarkup(text) {
+		audio, err := ssmlSpeaker.SynthesizeSSML(ctx, MarkupToSSML(text), voice)
+		if err == nil {
+			return audio, nil
+		}
+		if !errors.Is(err, ErrSSMLUnsupported) {
+			slog.WarnContext(ctx, "failed to synthesize ssml, falling back to plain text", "voice", voice, "err", err)
+		}
+	}
+	return speaker.Synthesize(ctx, StripMarkup(text), voice)
+}
+
+// Narration markup is a small set of tags the LLM can use in
+// narrator_markup to control how the text is spoken:
+//
+//	[pause] [long-pause] [emphasis]...[/emphasis] [whisper]...[/whisper] [shout]...[/shout]
+var markupTag = regexp.MustCompile(`\[(/?)(pause|long-pause|emphasis|whisper|shout)\]`)
+
+var (
+	ssmlOpen = map[string]string{
+		"pause":      `<break time="600ms"/>`,
+		"long-pause": `<break time="1500ms"/>`,
+		"emphasis":   `<emphasis level="strong">`,
+		"whisper":    `<prosody volume="x-soft" rate="slow">`,
+		"shout":      `<prosody volume="x-loud" pitch="+2st">`,
+	}
+	ssmlClose = map[string]string{
+		"emphasis": `</emphasis>`,
+		"whisper":  `</prosody>`,
+		"shout":    `</prosody>`,
+	}
+	multipleSpaces = regexp.MustCompile(`[ \t]{2,}`)
+)
+
+func HasMarkup(text string) bool {
+	return markupTag.MatchString(text)
+}
+
+// StripMarkup removes all narration markup from text.
+func StripMarkup(text string) string {
+	text = markupTag.ReplaceAllString(text, "")
+	text = multipleSpaces.ReplaceAllString(text, " ")
+	return strings.TrimSpace(text)
+}
+
+// MarkupToSSML translates narration markup to an SSML document.
+// Closing tags without matching opening tag are dropped and tags that are
+// still open at the end are closed.
+func MarkupToSSML(text string) string {
+	sb := strings.Builder{}
+	sb.WriteString("<speak>")
+	open := make([]string, 0)
+	last := 0
+	for _, m := range markupTag.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(html.EscapeString(text[last:m[0]]))
+		last = m[1]
+		closing := m[3] > m[2]
+		tag := text[m[4]:m[5]]
+		_, container := ssmlClose[tag]
+		switch {
+		case !container:
+			if !closing {
+				sb.WriteString(ssmlOpen[tag])
+			}
+		case !closing:
+			sb.WriteString(ssmlOpen[tag])
+			open = append(open, tag)
+		case len(open) > 0 && open[len(open)-1] == tag:
+			sb.WriteString(ssmlClose[tag])
+			open = open[:len(open)-1]
+		}
+	}
+	sb.WriteString(html.EscapeString(text[last:]))
+	for i := len(open) - 1; i >= 0; i-- {
+		sb.WriteString(ssmlClose[open[i]])
+	}
+	sb.WriteString("</speak>")
+	return sb.String()
 }
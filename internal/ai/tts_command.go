@@ -0,0 +1,89 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gameslabor/internal/config"
+	"gameslabor/internal/locale"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+func init() {
+	registerSpeaker("command", newCommandSpeaker, func(cfg *config.Config) bool {
+		return cfg.TTS.Command != ""
+	})
+}
+
+// commandSpeaker runs a local program (e.g. Piper or espeak-ng) for every
+// text. The text is written to stdin and the OGG audio is read from stdout.
+// The command is run by the system shell, so it can be a pipeline like
+//
+//	espeak-ng -v "$TTS_VOICE" --stdin --stdout | ffmpeg -loglevel error -i - -c:a libopus -f ogg -
+//
+// The voice to speak with is passed in the TTS_VOICE environment variable,
+// the language of the campaign (e.g. en-US) in TTS_LANGUAGE.
+// If tts.command_ssml is set, SSML is written to stdin instead of plain text
+// (e.g. for espeak-ng with the -m flag).
+type commandSpeaker struct {
+	command  string
+	voices   []string
+	ssml     bool
+	language string
+}
+
+func newCommandSpeaker(ctx context.Context, cfg *config.Config, language *locale.Locale) (Speaker, error) {
+	s := &commandSpeaker{command: cfg.TTS.Command, ssml: cfg.TTS.CommandSSML, language: language.TTSLanguage}
+	s.voices = slices.Clone(cfg.TTS.CommandVoices)
+	if len(s.voices) == 0 {
+		s.voices = []string{"default"}
+	}
+	return s, nil
+}
+
+func (s *commandSpeaker) Name() string {
+	program, _, _ := strings.Cut(strings.TrimSpace(s.command), " ")
+	return "command/" + program
+}
+
+func (s *commandSpeaker) Voices() []string {
+	return s.voices
+}
+
+func (s *commandSpeaker) SynthesizeSSML(ctx context.Context, ssml string, voice string) ([]byte, error) {
+	if !s.ssml {
+		return nil, ErrSSMLUnsupported
+	}
+	return s.Synthesize(ctx, ssml, voice)
+}
+
+func (s *commandSpeaker) Synthesize(ctx context.Context, text string, voice string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", s.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", s.command)
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Env = append(os.Environ(), "TTS_VOICE="+voice, "TTS_LANGUAGE="+s.language)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("tts command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("tts command produced no audio")
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *commandSpeaker) Close() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"gameslabor/internal/config"
+	"gameslabor/internal/locale"
+	"strings"
+
+	tts "cloud.google.com/go/texttospeech/apiv1"
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"google.golang.org/api/option"
+)
+
+var ttsAudioConfig = &texttospeechpb.AudioConfig{
+	AudioEncoding: texttospeechpb.AudioEncoding_OGG_OPUS,
+}
+
+func init() {
+	registerSpeaker("google", newGoogleSpeaker, nil)
+}
+
+// googleSpeaker uses Google Cloud Text-to-Speech.
+type googleSpeaker struct {
+	client       *tts.Client
+	languageCode string
+	voices       []string
+}
+
+// newGoogleSpeaker uses the Chirp3 HD voices in cfg.TTS.Voices, they exist in
+// every language as <language>-Chirp3-HD-<name>.
+func newGoogleSpeaker(ctx context.Context, cfg *config.Config, language *locale.Locale) (Speaker, error) {
+	client, err := tts.NewClient(ctx, option.WithAPIKey(cfg.GoogleAPIKey))
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create tts client"), err)
+	}
+	voices := make([]string, len(cfg.TTS.Voices))
+	for i, name := range cfg.TTS.Voices {
+		voices[i] = language.TTSLanguage + "-Chirp3-HD-" + name
+	}
+	return &googleSpeaker{client: client, languageCode: language.TTSLanguage, voices: voices}, nil
+}
+
+func (s *googleSpeaker) Name() string {
+	return "google"
+}
+
+func (s *googleSpeaker) Voices() []string {
+	return s.voices
+}
+
+func (s *googleSpeaker) Synthesize(ctx context.Context, text string, voice string) ([]byte, error) {
+	return s.synthesize(ctx, &texttospeechpb.SynthesisInput{
+		InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
+	}, voice)
+}
+
+// SynthesizeSSML returns ErrSSMLUnsupported for the Chirp3 HD voices without
+// a request, they reject SSML.
+func (s *googleSpeaker) SynthesizeSSML(ctx context.Context, ssml string, voice string) ([]byte, error) {
+	if strings.Contains(voice, "-Chirp3-HD-") {
+		return nil, ErrSSMLUnsupported
+	}
+	return s.synthesize(ctx, &texttospeechpb.SynthesisInput{
+		InputSource: &texttospeechpb.SynthesisInput_Ssml{Ssml: ssml},
+	}, voice)
+}
+
+func (s *googleSpeaker) synthesize(ctx context.Context, input *texttospeechpb.SynthesisInput, voice string) ([]byte, error) {
+	req := texttospeechpb.SynthesizeSpeechRequest{
+		Input: input,
+		Voice: &texttospeechpb.VoiceSelectionParams{
+			LanguageCode: s.languageCode,
+			Name:         voice,
+		},
+		AudioConfig: ttsAudioConfig,
+	}
+	resp, err := s.client.SynthesizeSpeech(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetAudioContent(), nil
+}
+
+func (s *googleSpeaker) Close() error {
+	return s.client.Close()
+}
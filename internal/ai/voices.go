@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// narratorSpeaker is the speaker of narration segments.
+const narratorSpeaker = "narrator"
+
+// SpeechSegment is a part of the narrator text spoken by one entity.
+type SpeechSegment struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+}
+
+// Voice returns the voice of entity, which is either an entity from
+// EntityData or empty for the narrator. Every entity gets its own voice on
+// first use, which it keeps for the rest of the campaign. Voices are reused
+// only when there are more entities than voices.
+// Voice changes ai.Voices and must not be called concurrently.
+func (ai *AI) Voice(entity string) string {
+	if ai.speaker == nil {
+		return ""
+	}
+	voices := ai.speaker.Voices()
+	if entity == "" || entity == narratorSpeaker || len(voices) < 2 {
+		return voices[0]
+	}
+	if voice, ok := ai.Voices[entity]; ok && slices.Contains(voices, voice) {
+		return voice
+	}
+
+	used := make(map[string]int, len(voices))
+	for _, voice := range ai.Voices {
+		used[voice]++
+	}
+	// among the least used voices, pick one by the name of the entity so
+	// the same campaign gets the same voices when it is replayed
+	candidates := voices[1:]
+	least := used[candidates[0]]
+	for _, voice := range candidates {
+		least = min(least, used[voice])
+	}
+	candidates = slices.DeleteFunc(slices.Clone(candidates), func(voice string) bool {
+		return used[voice] != least
+	})
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(entity))
+	voice := candidates[h.Sum32()%uint32(len(candidates))]
+
+	if ai.Voices == nil {
+		ai.Voices = make(map[string]string)
+	}
+	ai.Voices[entity] = voice
+	return voice
+}
+
+// speechSegments cleans up the segments of a response. It returns nil if the
+// whole text is spoken by the narrator, so no segments have to be stored.
+func speechSegments(segments []SpeechSegment) []SpeechSegment {
+	cleaned := make([]SpeechSegment, 0, len(segments))
+	multipleSpeakers := false
+	for _, segment := range segments {
+		segment.Text = strings.TrimSpace(segment.Text)
+		segment.Speaker = strings.TrimSpace(segment.Speaker)
+		if segment.Text == "" {
+			continue
+		}
+		if segment.Speaker == "" {
+			segment.Speaker = narratorSpeaker
+		}
+		if segment.Speaker != narratorSpeaker {
+			multipleSpeakers = true
+		}
+		// merge consecutive segments of the same speaker into one audio file
+		if n := len(cleaned); n > 0 && cleaned[n-1].Speaker == segment.Speaker {
+			cleaned[n-1].Text += " " + segment.Text
+			continue
+		}
+		cleaned = append(cleaned, segment)
+	}
+	if !multipleSpeakers {
+		return nil
+	}
+	return cleaned
+}
@@ -0,0 +1,227 @@
+// Package config holds the settings of the server and the commands.
+//
+// A Config is built by Load from, in increasing precedence, the defaults, a
+// YAML file, the .env file and environment variables, and the command line
+// flags. It is passed to the packages that need it, nothing is read from the
+// environment anywhere else.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var ErrMissingAPIKey = errors.New("google_api_key is not set (GOOGLE_API_KEY)")
+
+type (
+	Config struct {
+		GoogleAPIKey string `yaml:"google_api_key"`
+		Port         int    `yaml:"port"`
+		// DataDir is where the games are saved.
+		DataDir string `yaml:"data_dir"`
+		// AssetDir is where generated files are stored, default <data>/assets.
+		AssetDir string `yaml:"asset_dir"`
+		// AssetQuotaMB is the maximum size of the assets per game, 0 is unlimited.
+		AssetQuotaMB int `yaml:"asset_quota_mb"`
+		// ScenarioDir holds additional scenarios, default <data>/scenarios.
+		ScenarioDir string `yaml:"scenario_dir"`
+		// PromptDir overrides the prompt templates, default <data>/prompts.
+		PromptDir string `yaml:"prompt_dir"`
+		// PromptWatch reloads the prompt templates when a file in PromptDir
+		// changes, for tuning prompts while the server runs.
+		PromptWatch bool `yaml:"prompt_watch"`
+
+		Log    Log    `yaml:"log"`
+		LLM    LLM    `yaml:"llm"`
+		TTS    TTS    `yaml:"tts"`
+		STT    STT    `yaml:"stt"`
+		Images Images `yaml:"images"`
+		Safety Safety `yaml:"safety"`
+		Input  Input  `yaml:"input"`
+	}
+
+	Log struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	}
+
+	LLM struct {
+		Model         string  `yaml:"model"`
+		ThinkingModel string  `yaml:"thinking_model"`
+		Temperature   float32 `yaml:"temperature"`
+		TopP          float32 `yaml:"top_p"`
+		TopK          float32 `yaml:"top_k"`
+		// ThinkingBudget limits the tokens the thinking model thinks with
+		// when a campaign starts, -1 lets the model decide.
+		ThinkingBudget int `yaml:"thinking_budget"`
+		// MaxOutputTokens limits every response, 0 is the limit of the model.
+		MaxOutputTokens int `yaml:"max_output_tokens"`
+		// RecentChatHistory is the number of chat messages sent with every
+		// prompt, older messages are only known from the event history.
+		RecentChatHistory int `yaml:"recent_chat_history"`
+	}
+
+	TTS struct {
+		// Backend is the default TTS backend of new games.
+		Backend string `yaml:"backend"`
+		// Voices are the names of the Google voices, they exist in every
+		// language as <language>-Chirp3-HD-<name>. The first one narrates.
+		Voices []string `yaml:"voices"`
+		// Command reads text from stdin and writes OGG audio to stdout, it
+		// enables the command backend.
+		Command       string   `yaml:"command"`
+		CommandVoices []string `yaml:"command_voices"`
+		CommandSSML   bool     `yaml:"command_ssml"`
+	}
+
+	STT struct {
+		Backend      string `yaml:"backend"`
+		WhisperBin   string `yaml:"whisper_bin"`
+		WhisperModel string `yaml:"whisper_model"`
+	}
+
+	Images struct {
+		// Backend draws scene illustrations, empty turns them off.
+		Backend string `yaml:"backend"`
+		Model   string `yaml:"model"`
+	}
+
+	// Safety is the filter every narrator text passes before the players
+	// see it, in addition to the lines and veils of a table.
+	Safety struct {
+		// Keywords must not appear in any narrator text.
+		Keywords []string `yaml:"keywords"`
+		// ClassifierModel checks the narrator texts against the lines and
+		// veils of the table, empty only checks the keywords.
+		ClassifierModel string `yaml:"classifier_model"`
+		// Retries is how often a filtered response is generated again before
+		// it is replaced by a notice.
+		Retries int `yaml:"retries"`
+	}
+
+	// Input limits what the players send to the narrator.
+	Input struct {
+		// MaxLength is the maximum length of an input in characters.
+		MaxLength int `yaml:"max_length"`
+		// PerMinute is the number of inputs a player can send per minute,
+		// over all games, 0 is unlimited.
+		PerMinute int `yaml:"per_minute"`
+	}
+)
+
+// Default returns the configuration used if nothing else is set.
+func Default() *Config {
+	return &Config{
+		Port:         8080,
+		DataDir:      "data",
+		AssetQuotaMB: 512,
+		Log:          Log{Level: "info", Format: "text"},
+		LLM: LLM{
+			Model:             "gemini-2.5-flash",
+			ThinkingModel:     "gemini-2.5-flash",
+			Temperature:       0.7,
+			TopP:              0.5,
+			TopK:              5,
+			ThinkingBudget:    -1,
+			RecentChatHistory: 10,
+		},
+		TTS: TTS{
+			Backend: "google",
+			Voices: []string{
+				"Algenib",
+				"Achernar",
+				"Charon",
+				"Kore",
+				"Fenrir",
+				"Leda",
+				"Orus",
+				"Aoede",
+				"Puck",
+				"Zephyr",
+				"Enceladus",
+				"Despina",
+				"Iapetus",
+				"Gacrux",
+				"Umbriel",
+				"Sulafat",
+			},
+		},
+		STT:    STT{Backend: "gemini", WhisperBin: "whisper-cli"},
+		Images: Images{Model: "imagen-3.0-generate-002"},
+		Safety: Safety{Retries: 2},
+		Input:  Input{MaxLength: 1000, PerMinute: 10},
+	}
+}
+
+// AssetQuota is AssetQuotaMB in bytes.
+func (c *Config) AssetQuota() int64 {
+	return int64(c.AssetQuotaMB) << 20
+}
+
+// RequireGoogleAPIKey returns ErrMissingAPIKey if the key is not set. Only
+// commands that talk to Google need it.
+func (c *Config) RequireGoogleAPIKey() error {
+	if c.GoogleAPIKey == "" {
+		return ErrMissingAPIKey
+	}
+	return nil
+}
+
+// complete fills the settings that default to other settings.
+func (c *Config) complete() {
+	if c.AssetDir == "" {
+		c.AssetDir = filepath.Join(c.DataDir, "assets")
+	}
+	if c.ScenarioDir == "" {
+		c.ScenarioDir = filepath.Join(c.DataDir, "scenarios")
+	}
+	if c.PromptDir == "" {
+		c.PromptDir = filepath.Join(c.DataDir, "prompts")
+	}
+}
+
+// Validate returns all problems of the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port < 1<<16, "port %d is out of range", c.Port)
+	check(c.DataDir != "", "data_dir is empty")
+	check(c.AssetQuotaMB >= 0, "asset_quota_mb must not be negative")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level %q is not one of debug, info, warn, error", c.Log.Level)
+	check(slices.Contains([]string{"text", "json"}, strings.ToLower(c.Log.Format)), "log.format %q is not one of text, json", c.Log.Format)
+
+	check(c.LLM.Model != "", "llm.model is empty")
+	check(c.LLM.ThinkingModel != "", "llm.thinking_model is empty")
+	check(c.LLM.Temperature >= 0 && c.LLM.Temperature <= 2, "llm.temperature %g is not between 0 and 2", c.LLM.Temperature)
+	check(c.LLM.TopP >= 0 && c.LLM.TopP <= 1, "llm.top_p %g is not between 0 and 1", c.LLM.TopP)
+	check(c.LLM.TopK >= 1, "llm.top_k %g is less than 1", c.LLM.TopK)
+	check(c.LLM.ThinkingBudget >= -1, "llm.thinking_budget %d is less than -1", c.LLM.ThinkingBudget)
+	check(c.LLM.MaxOutputTokens >= 0, "llm.max_output_tokens %d is negative", c.LLM.MaxOutputTokens)
+	check(c.LLM.RecentChatHistory >= 1, "llm.recent_chat_history %d is less than 1", c.LLM.RecentChatHistory)
+
+	check(c.TTS.Backend != "", "tts.backend is empty")
+	check(len(c.TTS.Voices) > 0, "tts.voices is empty")
+	check(c.TTS.Backend != "command" || c.TTS.Command != "", "tts.backend is command, but tts.command is empty")
+
+	check(c.STT.Backend != "", "stt.backend is empty")
+	check(c.STT.Backend != "whisper" || c.STT.WhisperModel != "", "stt.backend is whisper, but stt.whisper_model is empty")
+
+	check(c.Images.Backend != "imagen" || c.Images.Model != "", "images.backend is imagen, but images.model is empty")
+
+	check(c.Safety.Retries >= 0, "safety.retries %d is negative", c.Safety.Retries)
+
+	check(c.Input.MaxLength >= 1, "input.max_length %d is less than 1", c.Input.MaxLength)
+	check(c.Input.PerMinute >= 0, "input.per_minute %d is negative", c.Input.PerMinute)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is read if it exists and no other file is given with --config
+// or CONFIG_FILE.
+const DefaultFile = "gameslabor.yaml"
+
+// Load builds the configuration for the command line args (without the
+// program name) and returns the arguments left after the flags, e.g. the
+// game id of a command.
+//
+// Later sources override earlier ones:
+//
+//  1. Default
+//  2. the YAML file given with --config or CONFIG_FILE, DefaultFile otherwise
+//  3. the .env file in the working directory and the environment variables
+//  4. the flags in args
+func Load(args []string) (*Config, []string, error) {
+	if err := loadDotEnv(".env"); err != nil {
+		return nil, nil, err
+	}
+
+	// the file comes before the flags, so --config is looked up first
+	filename, explicit := os.Getenv("CONFIG_FILE"), false
+	if filename != "" {
+		explicit = true
+	}
+	{
+		probe := flag.NewFlagSet("", flag.ContinueOnError)
+		probe.SetOutput(io.Discard)
+		newFlagSet(probe, Default())
+		probe.StringVar(&filename, "config", filename, "")
+		_ = probe.Parse(args)
+		probe.Visit(func(f *flag.Flag) {
+			if f.Name == "config" {
+				explicit = true
+			}
+		})
+	}
+	if filename == "" {
+		filename = DefaultFile
+	}
+
+	c := Default()
+	if err := c.readFile(filename); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	if err := c.readEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	newFlagSet(fs, c)
+	fs.String("config", filename, "YAML configuration file (CONFIG_FILE)")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c.complete()
+	if err := c.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return c, fs.Args(), nil
+}
+
+func (c *Config) readFile(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	// typos in the file should not be ignored silently
+	d.KnownFields(true)
+	if err := d.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// readEnv reads the environment variables, each one overrides one setting.
+func (c *Config) readEnv() error {
+	vars := []struct {
+		name string
+		set  func(v string) error
+	}{
+		{"GOOGLE_API_KEY", setString(&c.GoogleAPIKey)},
+		{"PORT", setInt(&c.Port)},
+		{"DATA_DIR", setString(&c.DataDir)},
+		{"ASSET_DIR", setString(&c.AssetDir)},
+		{"ASSET_QUOTA_MB", setInt(&c.AssetQuotaMB)},
+		{"SCENARIO_DIR", setString(&c.ScenarioDir)},
+		{"PROMPT_DIR", setString(&c.PromptDir)},
+		{"PROMPT_WATCH", setBool(&c.PromptWatch)},
+		{"LOG_LEVEL", setString(&c.Log.Level)},
+		{"LOG_FORMAT", setString(&c.Log.Format)},
+		{"LLM_MODEL", setString(&c.LLM.Model)},
+		{"LLM_THINKING_MODEL", setString(&c.LLM.ThinkingModel)},
+		{"LLM_TEMPERATURE", setFloat(&c.LLM.Temperature)},
+		{"LLM_TOP_P", setFloat(&c.LLM.TopP)},
+		{"LLM_TOP_K", setFloat(&c.LLM.TopK)},
+		{"LLM_THINKING_BUDGET", setInt(&c.LLM.ThinkingBudget)},
+		{"LLM_MAX_OUTPUT_TOKENS", setInt(&c.LLM.MaxOutputTokens)},
+		{"LLM_RECENT_CHAT_HISTORY", setInt(&c.LLM.RecentChatHistory)},
+		{"TTS_BACKEND", setString(&c.TTS.Backend)},
+		{"TTS_VOICES", setList(&c.TTS.Voices)},
+		{"TTS_COMMAND", setString(&c.TTS.Command)},
+		{"TTS_COMMAND_VOICES", setList(&c.TTS.CommandVoices)},
+		{"TTS_COMMAND_SSML", setBool(&c.TTS.CommandSSML)},
+		{"STT_BACKEND", setString(&c.STT.Backend)},
+		{"WHISPER_BIN", setString(&c.STT.WhisperBin)},
+		{"WHISPER_MODEL", setString(&c.STT.WhisperModel)},
+		{"IMAGE_BACKEND", setString(&c.Images.Backend)},
+		{"IMAGE_MODEL", setString(&c.Images.Model)},
+		{"SAFETY_KEYWORDS", setList(&c.Safety.Keywords)},
+		{"SAFETY_CLASSIFIER_MODEL", setString(&c.Safety.ClassifierModel)},
+		{"SAFETY_RETRIES", setInt(&c.Safety.Retries)},
+		{"INPUT_MAX_LENGTH", setInt(&c.Input.MaxLength)},
+		{"INPUT_PER_MINUTE", setInt(&c.Input.PerMinute)},
+	}
+	var errs []error
+	for _, v := range vars {
+		value, ok := os.LookupEnv(v.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("environment variable %s: %w", v.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newFlagSet defines the flags of the server on fs, their defaults are the
+// values of c.
+func newFlagSet(fs *flag.FlagSet, c *Config) {
+	fs.IntVar(&c.Port, "port", c.Port, "Port to listen on")
+	fs.StringVar(&c.DataDir, "data", c.DataDir, "Directory to store game states in")
+	fs.StringVar(&c.AssetDir, "assets", c.AssetDir, "Directory to store generated audio in (default <data>/assets)")
+	fs.IntVar(&c.AssetQuotaMB, "asset-quota", c.AssetQuotaMB, "Maximum size of the generated assets per game in MB, 0 is unlimited")
+	fs.StringVar(&c.ScenarioDir, "scenarios", c.ScenarioDir, "Directory with additional scenarios as <id>.md or <id>.json (default <data>/scenarios)")
+	fs.StringVar(&c.PromptDir, "prompts", c.PromptDir, "Directory with prompt templates as <language>/<name>.tmpl that override the built-in ones (default <data>/prompts)")
+	fs.BoolVar(&c.PromptWatch, "prompt-watch", c.PromptWatch, "Reload the prompt templates when they change")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "Log level (debug, info, warn, error)")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "Log format (text, json)")
+	fs.StringVar(&c.LLM.Model, "llm-model", c.LLM.Model, "Gemini model for the turns")
+	fs.StringVar(&c.LLM.ThinkingModel, "llm-thinking-model", c.LLM.ThinkingModel, "Gemini model for the start of a campaign")
+	fs.Func("llm-temperature", "Sampling temperature (default "+formatFloat(c.LLM.Temperature)+")", setFloat(&c.LLM.Temperature))
+	fs.Func("llm-top-p", "Nucleus sampling probability (default "+formatFloat(c.LLM.TopP)+")", setFloat(&c.LLM.TopP))
+	fs.Func("llm-top-k", "Number of tokens to sample from (default "+formatFloat(c.LLM.TopK)+")", setFloat(&c.LLM.TopK))
+	fs.IntVar(&c.LLM.ThinkingBudget, "llm-thinking-budget", c.LLM.ThinkingBudget, "Thinking tokens for the start of a campaign, -1 lets the model decide")
+	fs.IntVar(&c.LLM.MaxOutputTokens, "llm-max-output-tokens", c.LLM.MaxOutputTokens, "Maximum tokens of a response, 0 is the limit of the model")
+	fs.IntVar(&c.LLM.RecentChatHistory, "llm-recent-chat-history", c.LLM.RecentChatHistory, "Number of recent chat messages sent with every prompt")
+	fs.StringVar(&c.TTS.Backend, "tts", c.TTS.Backend, "Default TTS backend (google, command)")
+	fs.Func("tts-voices", "Comma separated Google voice names, the first one narrates", setList(&c.TTS.Voices))
+	fs.StringVar(&c.TTS.Command, "tts-command", c.TTS.Command, "Shell command that reads text from stdin and writes OGG audio to stdout")
+	fs.Func("tts-command-voices", "Comma separated voices for --tts-command, the first one narrates", setList(&c.TTS.CommandVoices))
+	fs.BoolVar(&c.TTS.CommandSSML, "tts-command-ssml", c.TTS.CommandSSML, "Write SSML instead of plain text to --tts-command")
+	fs.StringVar(&c.STT.Backend, "stt", c.STT.Backend, "Speech-to-text backend for recorded input (gemini, whisper)")
+	fs.StringVar(&c.STT.WhisperBin, "whisper-bin", c.STT.WhisperBin, "whisper.cpp executable")
+	fs.StringVar(&c.STT.WhisperModel, "whisper-model", c.STT.WhisperModel, "whisper.cpp model file, enables the whisper backend")
+	fs.StringVar(&c.Images.Backend, "images", c.Images.Backend, "Backend for scene illustrations (imagen, placeholder), empty turns them off")
+	fs.StringVar(&c.Images.Model, "image-model", c.Images.Model, "Imagen model for --images imagen")
+	fs.Func("safety-keywords", "Comma separated words that must not appear in a narrator text", setList(&c.Safety.Keywords))
+	fs.StringVar(&c.Safety.ClassifierModel, "safety-classifier", c.Safety.ClassifierModel, "Gemini model that checks narrator texts against the lines and veils, empty only checks keywords")
+	fs.IntVar(&c.Safety.Retries, "safety-retries", c.Safety.Retries, "How often a filtered response is generated again before it is replaced by a notice")
+	fs.IntVar(&c.Input.MaxLength, "input-max-length", c.Input.MaxLength, "Maximum length of a player input in characters")
+	fs.IntVar(&c.Input.PerMinute, "input-per-minute", c.Input.PerMinute, "Inputs a player can send per minute, 0 is unlimited")
+}
+
+// loadDotEnv sets the variables in filename as environment variables.
+// A missing file is not an error.
+func loadDotEnv(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		os.Setenv(key, strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %w", filename, err)
+	}
+	return nil
+}
+
+func setString(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*p = i
+		return nil
+	}
+}
+
+func setFloat(p *float32) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return err
+		}
+		*p = float32(f)
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+// setList parses a comma separated list.
+func setList(p *[]string) func(string) error {
+	return func(v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
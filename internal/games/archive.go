@@ -0,0 +1,432 @@
+package games
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/karmicdice"
+	"io"
+	"log/slog"
+	"os"
+	unixpath "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An archive is a zip file with everything needed to continue a campaign on
+// another server:
+//
+//	manifest.json  format, version and id of the game
+//	game.json      state, settings, turn, host and lineage
+//	ai.json        the memory of the AI (ai.AI)
+//	players.json   the players and their character sheets
+//	dice.json      the roll the players still have to make and the karmic weight
+//	assets/<name>  the generated audio and images
+//
+// Archives of older versions are migrated on import, see archiveMigrations.
+const (
+	ArchiveVersion = 1
+	archiveFormat  = "gameslabor-archive"
+	// maxArchiveFileSize limits the size of every file in an archive.
+	maxArchiveFileSize = 64 << 20
+)
+
+var (
+	ErrInvalidArchive = errors.New("invalid archive")
+	ErrArchiveVersion = errors.New("archive version is not supported")
+)
+
+var archiveAssetPattern = regexp.MustCompile(`^assets/[0-9a-f]{64}\.[a-z0-9]+$`)
+
+type (
+	archiveManifest struct {
+		Format  string    `json:"format"`
+		Version int       `json:"version"`
+		GameID  string    `json:"game_id"`
+		Created time.Time `json:"created"`
+	}
+
+	archiveGame struct {
+		ID             string    `json:"id"`
+		State          GameState `json:"state"`
+		AcceptingInput bool      `json:"accepting_input"`
+		Settings       Settings  `json:"settings"`
+		Turn           int       `json:"turn"`
+		Host           string    `json:"host,omitempty"`
+		Lineage        *Lineage  `json:"lineage,omitempty"`
+	}
+
+	archiveDice struct {
+		Roll   *DiceRoll `json:"roll"`
+		Weight float64   `json:"weight"`
+	}
+
+	// archive is a read archive, migrated to ArchiveVersion.
+	archive struct {
+		// files are the JSON files by name.
+		files map[string][]byte
+		// assets are the generated files by name.
+		assets map[string]*zip.File
+	}
+)
+
+// archiveMigrations upgrade the JSON files of an archive, the migration at
+// index i turns version i into version i+1.
+var archiveMigrations = []func(files map[string][]byte) error{
+	migrateArchive0,
+}
+
+// WriteArchive writes the game with all its generated files as zip to w.
+func (g *Game) WriteArchive(w io.Writer) error {
+	files, assets, err := g.archiveFiles()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	created := time.Now()
+	for _, name := range []string{"manifest.json", "game.json", "ai.json", "players.json", "dice.json"} {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: created})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	for _, path := range assets {
+		if err := writeArchiveAsset(zw, path); err != nil {
+			// e.g. removed from the asset dir by hand, the importing server
+			// generates it again
+			slog.Warn("asset is missing in archive", "game_id", g.ID, "path", path, "err", err)
+		}
+	}
+	return zw.Close()
+}
+
+// archiveFiles encodes the game under its lock, so a busy game is archived
+// after its turn. The assets are copied without the lock, they never change
+// once they are stored.
+func (g *Game) archiveFiles() (map[string][]byte, []string, error) {
+	g.turn.Lock()
+	defer g.turn.Unlock()
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	parts := map[string]any{
+		"manifest.json": archiveManifest{Format: archiveFormat, Version: ArchiveVersion, GameID: g.ID, Created: time.Now().UTC()},
+		"game.json":     archiveGame{ID: g.ID, State: g.State, AcceptingInput: g.AcceptingInput, Settings: g.Settings, Turn: g.Turn, Host: g.Host, Lineage: g.Lineage},
+		"ai.json":       g.AI,
+		"players.json":  g.Players,
+		"dice.json":     archiveDice{Roll: g.Roll, Weight: g.Dice.Weight},
+	}
+	files := make(map[string][]byte, len(parts))
+	for name, v := range parts {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, nil, err
+		}
+		files[name] = b
+	}
+	var assets []string
+	if g.AI != nil {
+		assets = g.AI.Assets()
+	}
+	return files, assets, nil
+}
+
+func writeArchiveAsset(zw *zip.Writer, path string) error {
+	src, modTime, err := ai.OpenAsset(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// audio and images are compressed already
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: "assets/" + unixpath.Base(path), Method: zip.Store, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// ImportArchive adds the game of an archive to the running games with the
+// importing player as host. The game keeps its id unless it is taken, then it
+// gets a new one.
+func ImportArchive(ctx context.Context, r io.ReaderAt, size int64, playerID string) (*Game, error) {
+	if err := begin(); err != nil {
+		return nil, err
+	}
+	defer end()
+
+	a, err := readArchive(r, size)
+	if err != nil {
+		return nil, err
+	}
+	// the id is reserved until the game is restored, so concurrent imports
+	// of the same archive don't install their assets under the same id
+	var reserved string
+	defer func() { release(reserved) }()
+	g, err := a.install(ctx, func(id string) bool {
+		if !reserve(id) {
+			return true
+		}
+		reserved = id
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.Host = playerID
+	if _, ok := g.Players[playerID]; !ok {
+		g.Players[playerID] = &Player{ID: playerID}
+	}
+	if err := restore(g, false); err != nil {
+		deleteAssets(g.ID)
+		return nil, err
+	}
+	slog.InfoContext(ctx, "game imported", "game_id", g.ID, "state", g.State.String(), "messages", len(g.AI.ChatHistory))
+	return g, nil
+}
+
+// ImportArchiveTo saves the game of an archive in dir, e.g. to import it from
+// the command line while the server is stopped. The game keeps its id unless
+// a game with that id is saved in dir already. It has no host, the first
+// player who joins becomes it.
+func ImportArchiveTo(ctx context.Context, dir string, r io.ReaderAt, size int64) (*Game, error) {
+	a, err := readArchive(r, size)
+	if err != nil {
+		return nil, err
+	}
+	g, err := a.install(ctx, func(id string) bool {
+		_, err := os.Stat(filepath.Join(dir, id+".json"))
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		deleteAssets(g.ID)
+		return nil, err
+	}
+	if err := g.save(dir); err != nil {
+		deleteAssets(g.ID)
+		return nil, err
+	}
+	return g, nil
+}
+
+// readArchive reads a zip archive or the save file of a single game
+// (<data>/<id>.json) and migrates it to the current version.
+func readArchive(r io.ReaderAt, size int64) (*archive, error) {
+	a := &archive{files: make(map[string][]byte), assets: make(map[string]*zip.File)}
+	version := 0
+
+	start := make([]byte, min(size, 512))
+	if _, err := r.ReadAt(start, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(start), []byte("{")) {
+		b, err := io.ReadAll(io.LimitReader(io.NewSectionReader(r, 0, size), maxArchiveFileSize))
+		if err != nil {
+			return nil, err
+		}
+		a.files["save.json"] = b
+	} else {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidArchive, err)
+		}
+		for _, f := range zr.File {
+			switch {
+			case strings.HasSuffix(f.Name, ".json") && !strings.Contains(f.Name, "/"):
+				b, err := readArchiveFile(f)
+				if err != nil {
+					return nil, errors.Join(ErrInvalidArchive, fmt.Errorf("%s: %w", f.Name, err))
+				}
+				a.files[f.Name] = b
+			case archiveAssetPattern.MatchString(f.Name):
+				a.assets[unixpath.Base(f.Name)] = f
+			}
+		}
+
+		if b, ok := a.files["manifest.json"]; ok {
+			manifest := archiveManifest{}
+			if err := json.Unmarshal(b, &manifest); err != nil {
+				return nil, errors.Join(ErrInvalidArchive, err)
+			}
+			if manifest.Format != archiveFormat {
+				return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidArchive, manifest.Format)
+			}
+			version = manifest.Version
+		}
+	}
+
+	if version > ArchiveVersion || version < 0 {
+		return nil, fmt.Errorf("%w: version %d, this server reads up to %d", ErrArchiveVersion, version, ArchiveVersion)
+	}
+	for ; version < ArchiveVersion; version++ {
+		if err := archiveMigrations[version](a.files); err != nil {
+			return nil, errors.Join(ErrInvalidArchive, fmt.Errorf("migration from version %d: %w", version, err))
+		}
+	}
+	return a, nil
+}
+
+func readArchiveFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, maxArchiveFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxArchiveFileSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxArchiveFileSize)
+	}
+	return b, nil
+}
+
+// migrateArchive0 splits the save file of a game, which is the only file of
+// archives without a manifest, into the files of version 1.
+func migrateArchive0(files map[string][]byte) error {
+	var save []byte
+	for name, b := range files {
+		if save != nil {
+			return errors.New("more than one game in archive")
+		}
+		save = b
+		delete(files, name)
+	}
+	if save == nil {
+		return errors.New("no game in archive")
+	}
+
+	g := &Game{}
+	if err := json.Unmarshal(save, g); err != nil {
+		return err
+	}
+	parts := map[string]any{
+		"manifest.json": archiveManifest{Format: archiveFormat, Version: 1, GameID: g.ID},
+		"game.json":     archiveGame{ID: g.ID, State: g.State, AcceptingInput: g.AcceptingInput, Settings: g.Settings, Turn: g.Turn, Host: g.Host, Lineage: g.Lineage},
+		"ai.json":       g.AI,
+		"players.json":  g.Players,
+		"dice.json":     archiveDice{Roll: g.Roll, Weight: g.Dice.Weight},
+	}
+	for name, v := range parts {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		files[name] = b
+	}
+	return nil
+}
+
+// install builds the game of the archive without a host and stores its
+// assets. taken reports whether a game id is used already, install asks
+// again with new ids until it finds a free one. The settings and the memory
+// are checked like those of a new game.
+func (a *archive) install(ctx context.Context, taken func(id string) bool) (*Game, error) {
+	meta := archiveGame{}
+	dice := archiveDice{}
+	g := &Game{AI: ai.Empty(), Players: make(map[string]*Player)}
+	for name, v := range map[string]any{"game.json": &meta, "ai.json": &g.AI, "players.json": &g.Players, "dice.json": &dice} {
+		b, ok := a.files[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is missing", ErrInvalidArchive, name)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, errors.Join(ErrInvalidArchive, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if meta.State != GameStateInit && meta.State != GameStateRunning {
+		return nil, fmt.Errorf("%w: unknown state %d", ErrInvalidArchive, meta.State)
+	}
+	if meta.Settings.CustomScenario != nil {
+		if err := meta.Settings.CustomScenario.Validate(); err != nil {
+			return nil, errors.Join(ErrInvalidArchive, err)
+		}
+	}
+	if g.AI == nil {
+		g.AI = ai.Empty()
+	}
+	if g.Players == nil {
+		g.Players = make(map[string]*Player)
+	}
+	for _, models := range []ai.ModelSettings{meta.Settings.Models, g.AI.Models} {
+		if err := ai.ValidateModelSettings(ctx, cfg, models); err != nil {
+			return nil, errors.Join(ErrInvalidArchive, err)
+		}
+	}
+	boundaries, err := meta.Settings.Boundaries.Normalize()
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArchive, err)
+	}
+	meta.Settings.Boundaries = boundaries
+	if g.AI.Boundaries, err = g.AI.Boundaries.NormalizePlayed(); err != nil {
+		return nil, errors.Join(ErrInvalidArchive, err)
+	}
+	if err := (karmicdice.Dice{Weight: dice.Weight}).Validate(); err != nil {
+		return nil, errors.Join(ErrInvalidArchive, err)
+	}
+	// a roll waits for a running game, with a difficulty the response schema
+	// allows
+	if r := dice.Roll; r != nil && (meta.State != GameStateRunning || r.Difficulty < 1 || r.Difficulty > 20) {
+		return nil, fmt.Errorf("%w: invalid roll", ErrInvalidArchive)
+	}
+
+	id := meta.ID
+	if _, err := uuid.Parse(id); err != nil {
+		id = uuid.NewString()
+	}
+	for taken(id) {
+		id = uuid.NewString()
+	}
+	g.ID = id
+	g.State = meta.State
+	g.AcceptingInput = meta.AcceptingInput
+	g.Settings = meta.Settings
+	g.Turn = meta.Turn
+	g.Lineage = meta.Lineage
+	g.Roll = dice.Roll
+	g.Dice.Weight = dice.Weight
+	g.AI.GameID = id
+
+	renamed := make(map[string]string)
+	for _, path := range g.AI.Assets() {
+		f, ok := a.assets[unixpath.Base(path)]
+		if !ok {
+			continue
+		}
+		newPath, err := installArchiveAsset(id, f)
+		if err != nil {
+			deleteAssets(id)
+			return nil, err
+		}
+		renamed[path] = newPath
+	}
+	g.AI.RenameAssets(renamed)
+	g.AI.DropMissingAssets()
+	return g, nil
+}
+
+func installArchiveAsset(game string, f *zip.File) (string, error) {
+	data, err := readArchiveFile(f)
+	if err != nil {
+		return "", errors.Join(ErrInvalidArchive, fmt.Errorf("%s: %w", f.Name, err))
+	}
+	// the store names the file by its content, a changed file gets a new name
+	return ai.PutAsset(game, unixpath.Ext(f.Name), data)
+}
@@ -0,0 +1,235 @@
+// Package eval plays scripted sessions against the configured LLM and checks
+// every response of the narrator with rule-based validators, so changes of
+// prompts and models can be compared by numbers instead of by feeling.
+//
+// A script is a YAML file with the settings of a campaign, its players and
+// the inputs they send, see Script. The inputs are sent one after another,
+// rolls the narrator asks for are rolled before the next input.
+package eval
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/games"
+	"gameslabor/internal/karmicdice"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/prompts"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+	"gopkg.in/yaml.v3"
+)
+
+// maxRolls limits the rolls in a row before the next input is sent anyway.
+const maxRolls = 3
+
+// files are the built-in scripts.
+//
+//go:embed scripts/*.yaml
+var files embed.FS
+
+var ErrNoScripts = errors.New("no eval scripts")
+
+type (
+	// Script is a scripted session. Input i is sent by player i modulo the
+	// number of players.
+	Script struct {
+		// Name is the file name without .yaml.
+		Name          string             `json:"name" yaml:"-"`
+		Description   string             `json:"description" yaml:"description"`
+		Scenario      string             `json:"scenario" yaml:"scenario"`
+		Language      string             `json:"language" yaml:"language"`
+		ViolenceLevel uint8              `json:"violence_level" yaml:"violence_level"`
+		Duration      uint8              `json:"duration" yaml:"duration"`
+		Players       []games.PlayerData `json:"players" yaml:"players"`
+		Inputs        []string           `json:"inputs" yaml:"inputs"`
+	}
+)
+
+// LoadScripts returns the built-in scripts and the *.yaml files in dir, a
+// file with the name of a built-in script replaces it. An empty dir only
+// returns the built-in scripts. The scripts are sorted by name.
+func LoadScripts(dir string) ([]Script, error) {
+	byName := make(map[string]Script)
+	var errs []error
+
+	builtin, err := fs.Glob(files, "scripts/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range builtin {
+		b, err := files.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseScript(filename, b)
+		if err != nil {
+			return nil, err
+		}
+		byName[s.Name] = s
+	}
+
+	if dir != "" {
+		filenames, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range filenames {
+			b, err := os.ReadFile(filename)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			s, err := parseScript(filename, b)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			byName[s.Name] = s
+		}
+	}
+
+	scripts := make([]Script, 0, len(byName))
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		scripts = append(scripts, byName[name])
+	}
+	return scripts, errors.Join(errs...)
+}
+
+func parseScript(filename string, b []byte) (Script, error) {
+	s := Script{Name: strings.TrimSuffix(filepath.Base(filename), ".yaml")}
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(&s); err != nil {
+		return Script{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if s.Scenario == "" {
+		return Script{}, fmt.Errorf("%s: scenario is empty", filename)
+	}
+	if len(s.Players) == 0 {
+		return Script{}, fmt.Errorf("%s: no players", filename)
+	}
+	if _, err := locale.Get(s.Language); err != nil {
+		return Script{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	return s, nil
+}
+
+// Run plays every script and checks the responses. The settings that are not
+// part of a script, e.g. the models, are the ones of settings.
+func Run(ctx context.Context, scripts []Script, settings games.Settings) (*Report, error) {
+	if len(scripts) == 0 {
+		return nil, ErrNoScripts
+	}
+	report := &Report{
+		ID:      time.Now().UTC().Format("20060102-150405"),
+		Started: time.Now().UTC(),
+		Models:  settings.Models,
+		Prompts: prompts.Current().Dir(),
+		Scripts: make([]ScriptResult, 0, len(scripts)),
+	}
+	for _, s := range scripts {
+		slog.InfoContext(ctx, "running eval script", "script", s.Name)
+		report.Scripts = append(report.Scripts, runScript(ctx, s, settings))
+	}
+	report.Duration = time.Since(report.Started).Round(time.Millisecond).String()
+	report.summarize()
+	return report, nil
+}
+
+func runScript(ctx context.Context, s Script, settings games.Settings) ScriptResult {
+	result := ScriptResult{Name: s.Name, Scenario: s.Scenario, Language: s.Language}
+
+	settings.Scenario = s.Scenario
+	settings.CustomScenario = nil
+	settings.Language = s.Language
+	settings.ViolenceLevel = s.ViolenceLevel
+	settings.Duration = s.Duration
+	players := make(map[string]*games.Player, len(s.Players))
+	order := make([]string, 0, len(s.Players))
+	for i, description := range s.Players {
+		id := fmt.Sprintf("eval%d", i+1)
+		players[id] = &games.Player{ID: id, Description: description}
+		order = append(order, id)
+	}
+
+	a, prompt, err := games.NewAI(ctx, "eval-"+s.Name, settings, players)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer a.Close()
+
+	t := &turn{locale: locale.GetOrDefault(s.Language), players: players}
+	play := func(kind string, input string, prompt string) bool {
+		start := time.Now()
+		resp, err := a.Generate(ctx, kind == prompts.Start, genai.Text(prompt))
+		if err != nil {
+			result.Error = err.Error()
+			return false
+		}
+		a.ChatHistory = append(a.ChatHistory, resp.ChatMessage())
+
+		t.Number++
+		t.Kind = kind
+		t.Response = resp
+		result.Turns = append(result.Turns, TurnResult{
+			Number:       t.Number,
+			Kind:         kind,
+			Input:        input,
+			NarratorText: resp.NarratorText,
+			RollDice:     resp.RollDice,
+			Duration:     time.Since(start).Round(time.Millisecond).String(),
+			Checks:       check(t),
+		})
+		return true
+	}
+
+	if !play(prompts.Start, "", prompt) {
+		return result
+	}
+	for i, input := range s.Inputs {
+		for rolls := 0; t.Response.RollDice != nil && rolls < maxRolls; rolls++ {
+			roll := ai.DiceRoll{
+				Difficulty: uint8(t.Response.RollDice.Difficulty),
+				Result:     uint8(karmicdice.Int(t.Response.RollDice.Difficulty)),
+			}
+			a.ChatHistory[len(a.ChatHistory)-1].Roll = &roll
+			name := prompts.RollFailure
+			if roll.Result >= roll.Difficulty {
+				name = prompts.RollSuccess
+			}
+			prompt, err := a.Prompt(name, prompts.RollData{Result: roll.Result, Difficulty: roll.Difficulty})
+			if err != nil {
+				result.Error = err.Error()
+				return result
+			}
+			if !play(name, "", prompt) {
+				return result
+			}
+		}
+
+		player := players[order[i%len(order)]]
+		a.ChatHistory = append(a.ChatHistory, ai.ChatMessage{Role: "user", PlayerID: player.ID, Message: input})
+		name, prompt, err := games.InputPrompt(a, player.Prompt(), input)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if !play(name, input, prompt) {
+			return result
+		}
+	}
+	return result
+}
@@ -0,0 +1,195 @@
+package eval
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"html/template"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	//go:embed report.html
+	reportHTML string
+	reportTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+		"percent": percent,
+	}).Parse(reportHTML))
+)
+
+type (
+	// Report is the result of a run of all scripts.
+	Report struct {
+		// ID is the start time, reports sort by it.
+		ID       string           `json:"id"`
+		Started  time.Time        `json:"started"`
+		Duration string           `json:"duration"`
+		Models   ai.ModelSettings `json:"models"`
+		// Prompts is the override dir of the prompt templates, empty for the
+		// embedded ones.
+		Prompts string         `json:"prompts"`
+		Scripts []ScriptResult `json:"scripts"`
+		Summary Summary        `json:"summary"`
+	}
+
+	ScriptResult struct {
+		Name     string       `json:"name"`
+		Scenario string       `json:"scenario"`
+		Language string       `json:"language"`
+		Turns    []TurnResult `json:"turns"`
+		// Error is set if the script was aborted.
+		Error string `json:"error,omitempty"`
+	}
+
+	TurnResult struct {
+		Number int `json:"number"`
+		// Kind is the name of the prompt template of the turn.
+		Kind         string       `json:"kind"`
+		Input        string       `json:"input,omitempty"`
+		NarratorText string       `json:"narrator_text"`
+		RollDice     *ai.RollDice `json:"roll_dice,omitempty"`
+		Duration     string       `json:"duration"`
+		Checks       []Check      `json:"checks"`
+	}
+
+	// Summary counts the results of the checks by validator.
+	Summary struct {
+		Turns      int                `json:"turns"`
+		Errors     int                `json:"errors"`
+		Validators []ValidatorSummary `json:"validators"`
+	}
+
+	ValidatorSummary struct {
+		Validator string `json:"validator"`
+		Passed    int    `json:"passed"`
+		Failed    int    `json:"failed"`
+		Skipped   int    `json:"skipped"`
+	}
+)
+
+// Failed returns the number of failed checks of all validators.
+func (s Summary) Failed() int {
+	failed := 0
+	for _, v := range s.Validators {
+		failed += v.Failed
+	}
+	return failed
+}
+
+// Get returns the counts of the validator.
+func (s Summary) Get(validator string) ValidatorSummary {
+	for _, v := range s.Validators {
+		if v.Validator == validator {
+			return v
+		}
+	}
+	return ValidatorSummary{Validator: validator}
+}
+
+func (r *Report) summarize() {
+	r.Summary = Summary{Validators: make([]ValidatorSummary, len(Validators))}
+	for i, v := range Validators {
+		r.Summary.Validators[i].Validator = v.Name
+	}
+	for _, s := range r.Scripts {
+		if s.Error != "" {
+			r.Summary.Errors++
+		}
+		for _, t := range s.Turns {
+			r.Summary.Turns++
+			for _, c := range t.Checks {
+				i := slices.IndexFunc(r.Summary.Validators, func(v ValidatorSummary) bool { return v.Validator == c.Validator })
+				if i < 0 {
+					continue
+				}
+				switch c.Result {
+				case Passed:
+					r.Summary.Validators[i].Passed++
+				case Failed:
+					r.Summary.Validators[i].Failed++
+				default:
+					r.Summary.Validators[i].Skipped++
+				}
+			}
+		}
+	}
+}
+
+// WriteJSON saves the report as <dir>/<id>.json.
+func (r *Report) WriteJSON(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, r.ID+".json"), b, 0644)
+}
+
+// ReadReports returns the reports saved in dir, the oldest first.
+func ReadReports(dir string) ([]*Report, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(filenames)
+	reports := make([]*Report, 0, len(filenames))
+	var errs []error
+	for _, filename := range filenames {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r := &Report{}
+		if err := json.Unmarshal(b, r); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filename, err))
+			continue
+		}
+		reports = append(reports, r)
+	}
+	return reports, errors.Join(errs...)
+}
+
+// WriteHTML writes <dir>/index.html, which compares the pass rates of the
+// validators over the reports and shows the details of the last one.
+func WriteHTML(dir string, reports []*Report) error {
+	if len(reports) == 0 {
+		return errors.New("no eval reports")
+	}
+	validators := make([]string, 0, len(Validators))
+	descriptions := make(map[string]string, len(Validators))
+	for _, v := range Validators {
+		validators = append(validators, v.Name)
+		descriptions[v.Name] = v.Description
+	}
+
+	var b strings.Builder
+	err := reportTmpl.ExecuteTemplate(&b, "page", struct {
+		Reports      []*Report
+		Latest       *Report
+		Validators   []string
+		Descriptions map[string]string
+	}{reports, reports[len(reports)-1], validators, descriptions})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.html"), []byte(b.String()), 0644)
+}
+
+// percent returns the share of passed checks of the checks that applied.
+func percent(v ValidatorSummary) string {
+	if v.Passed+v.Failed == 0 {
+		return "–"
+	}
+	return fmt.Sprintf("%.0f %%", 100*float64(v.Passed)/float64(v.Passed+v.Failed))
+}
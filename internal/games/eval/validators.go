@@ -0,0 +1,160 @@
+package eval
+
+import (
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/games"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/prompts"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The results of a check.
+const (
+	Passed  = "passed"
+	Failed  = "failed"
+	Skipped = "skipped"
+)
+
+type (
+	// Check is the result of a validator for one response.
+	Check struct {
+		Validator string `json:"validator"`
+		Result    string `json:"result"`
+		// Message tells why the check failed.
+		Message string `json:"message,omitempty"`
+	}
+
+	// turn is what the validators get to see of a response.
+	turn struct {
+		Number   int
+		Kind     string
+		Response ai.ResponseSchema
+		locale   *locale.Locale
+		players  map[string]*games.Player
+	}
+
+	// validator returns Passed, Failed with a message or Skipped if the rule
+	// does not apply to the turn.
+	validator struct {
+		Name        string
+		Description string
+		check       func(t *turn) (string, string)
+	}
+)
+
+// Validators are all rules a response is checked with.
+var Validators = []validator{
+	{"narrator_text", "narrator_text is not empty", checkNarratorText},
+	{"dice_announcement", "a response with roll_dice asks for the roll in its last sentences", checkDiceAnnouncement},
+	{"informal_address", "the players are not addressed formally, e.g. with \"Sie\"", checkInformalAddress},
+	{"player_entities", "players are stored in entity_data as player_<id>", checkPlayerEntities},
+	{"memory", "the response adds to the memory, the start also to event_plan and entity_data", checkMemory},
+}
+
+// sentenceEnd splits a text into sentences, closing quotes stay with the
+// sentence.
+var sentenceEnd = regexp.MustCompile(`[.!?…]+["“”»«'’)]*\s+`)
+
+func check(t *turn) []Check {
+	checks := make([]Check, 0, len(Validators))
+	for _, v := range Validators {
+		result, message := v.check(t)
+		checks = append(checks, Check{Validator: v.Name, Result: result, Message: message})
+	}
+	return checks
+}
+
+func checkNarratorText(t *turn) (string, string) {
+	if strings.TrimSpace(t.Response.NarratorText) == "" {
+		return Failed, "narrator_text is empty"
+	}
+	return Passed, ""
+}
+
+func checkDiceAnnouncement(t *turn) (string, string) {
+	if t.Response.RollDice == nil {
+		return Skipped, ""
+	}
+	sentences := sentenceEnd.Split(strings.TrimSpace(t.Response.NarratorText), -1)
+	last := strings.ToLower(strings.Join(sentences[max(len(sentences)-2, 0):], " "))
+	for _, word := range t.locale.Eval.DiceWords {
+		if strings.Contains(last, strings.ToLower(word)) {
+			return Passed, ""
+		}
+	}
+	return Failed, fmt.Sprintf("roll_dice is set, but the last sentences don't mention it: %q", last)
+}
+
+// checkInformalAddress looks for the words of a formal address that don't
+// start a sentence, at the start they may as well mean "she" or "they".
+func checkInformalAddress(t *turn) (string, string) {
+	formal := t.locale.Eval.FormalAddress
+	if len(formal) == 0 {
+		return Skipped, ""
+	}
+	var found []string
+	sentenceStart := true
+	for _, field := range strings.Fields(t.Response.NarratorText) {
+		word := strings.TrimFunc(field, func(r rune) bool { return !unicode.IsLetter(r) })
+		// the first word of quoted speech starts a sentence as well
+		opensQuote := strings.IndexFunc(field, unicode.IsLetter) > 0
+		if !sentenceStart && !opensQuote && slices.Contains(formal, word) {
+			found = append(found, field)
+		}
+		sentenceStart = strings.ContainsAny(field[max(len(field)-3, 0):], ".!?:…")
+	}
+	if len(found) > 0 {
+		return Failed, "formal address: " + strings.Join(found, ", ")
+	}
+	return Passed, ""
+}
+
+func checkPlayerEntities(t *turn) (string, string) {
+	if len(t.Response.EntityData) == 0 {
+		return Skipped, ""
+	}
+	var problems []string
+	for _, e := range t.Response.EntityData {
+		name := strings.TrimSpace(e.EntityName)
+		if id, ok := strings.CutPrefix(strings.ToLower(name), "player"); ok {
+			id = strings.TrimLeft(id, "_ ")
+			if _, known := t.players[id]; !known || name != "player_"+id {
+				problems = append(problems, fmt.Sprintf("%q is no player_<id> of a player", name))
+			}
+			continue
+		}
+		for _, p := range t.players {
+			if p.Description.Name != "" && strings.EqualFold(name, p.Description.Name) {
+				problems = append(problems, fmt.Sprintf("%q is stored by name instead of as player_%s", name, p.ID))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return Failed, strings.Join(problems, "; ")
+	}
+	return Passed, ""
+}
+
+func checkMemory(t *turn) (string, string) {
+	r := t.Response
+	var missing []string
+	if len(r.EventLongHistory) == 0 && len(r.EventShortHistory) == 0 {
+		missing = append(missing, "event_long_history or event_short_history")
+	}
+	if t.Kind == prompts.Start {
+		if len(r.EventPlan) == 0 {
+			missing = append(missing, "event_plan")
+		}
+		if len(r.EntityData) == 0 {
+			missing = append(missing, "entity_data")
+		}
+	}
+	if len(missing) > 0 {
+		return Failed, "empty: " + strings.Join(missing, ", ")
+	}
+	return Passed, ""
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"archive/zip"
+	"io"
+	"text/template"
+	"time"
+)
+
+const (
+	epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+	xmlDeclaration = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+)
+
+// epubTmpl holds the XML files of the book, values are escaped with the
+// html function of text/template.
+var epubTmpl = template.Must(template.New("epub").Parse(`
+{{- define "content.opf" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">urn:uuid:{{html .Story.ID}}</dc:identifier>
+    <dc:title>{{html .Story.Title}}</dc:title>
+    <dc:language>{{html .Story.Language.Code}}</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="story" href="story.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="story"/>
+  </spine>
+</package>
+{{end}}
+
+{{- define "nav.xhtml" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{html .Story.Language.Code}}" xml:lang="{{html .Story.Language.Code}}">
+<head>
+<meta charset="utf-8"/>
+<title>{{html .Story.Title}}</title>
+</head>
+<body>
+<nav epub:type="toc">
+<ol>
+<li><a href="story.xhtml">{{html .Story.Language.Export.Story}}</a></li>
+</ol>
+</nav>
+</body>
+</html>
+{{end}}`))
+
+// writeEPUB writes an EPUB 3 book with the story as its only chapter.
+func writeEPUB(w io.Writer, s *story) error {
+	zw := zip.NewWriter(w)
+
+	// the mimetype has to be the first file and must not be compressed
+	modified := time.Now().UTC()
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	if err := writeZipFile(zw, "META-INF/container.xml", modified, func(w io.Writer) error {
+		_, err := io.WriteString(w, epubContainer)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	data := struct {
+		Story    *story
+		Modified string
+	}{s, modified.Format("2006-01-02T15:04:05Z")}
+	for _, name := range []string{"content.opf", "nav.xhtml"} {
+		if err := writeZipFile(zw, "OEBPS/"+name, modified, func(w io.Writer) error {
+			return epubTmpl.ExecuteTemplate(w, name, data)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := writeZipFile(zw, "OEBPS/story.xhtml", modified, func(w io.Writer) error {
+		if _, err := io.WriteString(w, xmlDeclaration); err != nil {
+			return err
+		}
+		return storyTmpl.ExecuteTemplate(w, "chapter", s)
+	}); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, modified time.Time, write func(w io.Writer) error) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	return write(f)
+}
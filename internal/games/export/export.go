@@ -0,0 +1,240 @@
+// Package export renders the story of a campaign for reading it back after
+// a session: as a Markdown transcript, a standalone HTML page or an EPUB
+// storybook.
+package export
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"gameslabor/internal/games"
+	"gameslabor/internal/games/scenarios"
+	"gameslabor/internal/locale"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+	EPUB     Format = "epub"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+type (
+	Options struct {
+		// GMAppendix adds the long term memory and the entity data of the AI.
+		GMAppendix bool
+		// Audio adds links to the narration audio.
+		Audio bool
+		// BaseURL is put before the audio paths (/ai/<game>/<name>),
+		// e.g. https://example.com. Empty keeps the links relative.
+		BaseURL string
+	}
+
+	// story is the campaign prepared for the renderers.
+	story struct {
+		ID         string
+		Title      string
+		Language   *locale.Locale
+		Characters []character
+		Entries    []entry
+		// Appendix is only set if Options.GMAppendix is.
+		Appendix *appendix
+	}
+
+	character struct {
+		Name        string
+		Description []string
+	}
+
+	entry struct {
+		// Player is the character name of the player, empty for the narrator.
+		Player     string
+		Paragraphs []string
+		Roll       *roll
+		Audio      []string
+	}
+
+	roll struct {
+		Text    string
+		Success bool
+		Outcome string
+	}
+
+	appendix struct {
+		Events   []string
+		Entities []entity
+	}
+
+	entity struct {
+		Name string
+		Data []string
+	}
+)
+
+// ParseFormat accepts the format names and their file extensions.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "markdown", "md":
+		return Markdown, nil
+	case "html", "htm":
+		return HTML, nil
+	case "epub":
+		return EPUB, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownFormat, s)
+}
+
+func (f Format) Extension() string {
+	switch f {
+	case Markdown:
+		return ".md"
+	case HTML:
+		return ".html"
+	case EPUB:
+		return ".epub"
+	}
+	return ""
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	case EPUB:
+		return "application/epub+zip"
+	}
+	return "application/octet-stream"
+}
+
+// Filename returns a file name for the export of g, based on its title.
+func Filename(g *games.Game, f Format) string {
+	v := g.View()
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '_'
+		}
+		return -1
+	}, title(v.Settings))
+	if name == "" {
+		name = v.ID
+	}
+	return name + f.Extension()
+}
+
+// Write renders the campaign g in the given format to w. It renders a
+// snapshot of g, so a running game is exported as it was when Write started.
+func Write(w io.Writer, g *games.Game, f Format, opts Options) error {
+	s := newStory(g.Snapshot(), opts)
+	switch f {
+	case Markdown:
+		return writeMarkdown(w, s)
+	case HTML:
+		return writeHTML(w, s)
+	case EPUB:
+		return writeEPUB(w, s)
+	}
+	return fmt.Errorf("%w %q", ErrUnknownFormat, f)
+}
+
+func newStory(g *games.Game, opts Options) *story {
+	l := locale.GetOrDefault(g.Settings.Language)
+	s := &story{
+		ID:       g.ID,
+		Title:    title(g.Settings),
+		Language: l,
+	}
+
+	players := slices.SortedFunc(maps.Values(g.Players), func(a, b *games.Player) int {
+		return cmp.Compare(a.Description.Name, b.Description.Name)
+	})
+	for _, p := range players {
+		s.Characters = append(s.Characters, character{
+			Name:        playerName(g, p.ID),
+			Description: p.Description.Slice(l),
+		})
+	}
+
+	if g.AI == nil {
+		return s
+	}
+	for _, m := range g.AI.ChatHistory {
+		e := entry{Paragraphs: paragraphs(m.Message)}
+		if m.Role == "user" {
+			e.Player = playerName(g, m.PlayerID)
+		}
+		if m.Roll != nil {
+			e.Roll = &roll{
+				Text:    fmt.Sprintf(l.Export.Roll, m.Roll.Result, m.Roll.Difficulty),
+				Success: m.Roll.Result >= m.Roll.Difficulty,
+				Outcome: l.Export.RollFailure,
+			}
+			if e.Roll.Success {
+				e.Roll.Outcome = l.Export.RollSuccess
+			}
+		}
+		if opts.Audio {
+			audio := m.AudioSegments
+			if len(audio) == 0 && m.Audio != "" {
+				audio = []string{m.Audio}
+			}
+			for _, a := range audio {
+				e.Audio = append(e.Audio, opts.BaseURL+a)
+			}
+		}
+		s.Entries = append(s.Entries, e)
+	}
+
+	if opts.GMAppendix {
+		a := &appendix{Events: g.AI.EventLongHistory}
+		for _, name := range slices.Sorted(maps.Keys(g.AI.EntityData)) {
+			display := name
+			if id, ok := strings.CutPrefix(name, "player_"); ok && g.Players[id] != nil {
+				display = playerName(g, id)
+			}
+			a.Entities = append(a.Entities, entity{Name: display, Data: g.AI.EntityData[name]})
+		}
+		s.Appendix = a
+	}
+	return s
+}
+
+// title returns the title of the scenario the campaign is played in.
+func title(s games.Settings) string {
+	if s.CustomScenario != nil {
+		return s.CustomScenario.Title
+	}
+	if scenario, err := scenarios.Get(s.Scenario, s.Language); err == nil {
+		return scenario.Title
+	}
+	return s.Scenario
+}
+
+func playerName(g *games.Game, playerID string) string {
+	if p, ok := g.Players[playerID]; ok && p.Description.Name != "" {
+		return p.Description.Name
+	}
+	return locale.GetOrDefault(g.Settings.Language).Export.Player
+}
+
+// paragraphs splits a message at blank lines.
+func paragraphs(text string) []string {
+	var list []string
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
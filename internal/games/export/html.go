@@ -0,0 +1,17 @@
+package export
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+)
+
+var (
+	//go:embed story.html
+	storyHTML string
+	storyTmpl = template.Must(template.New("story").Parse(storyHTML))
+)
+
+func writeHTML(w io.Writer, s *story) error {
+	return storyTmpl.ExecuteTemplate(w, "page", s)
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+func writeMarkdown(w io.Writer, s *story) error {
+	bw := bufio.NewWriter(w)
+	t := s.Language.Export
+
+	bw.WriteString("# " + s.Title + "\n")
+
+	if len(s.Characters) > 0 {
+		bw.WriteString("\n## " + t.Characters + "\n")
+		for _, c := range s.Characters {
+			bw.WriteString("\n### " + c.Name + "\n\n")
+			for _, d := range c.Description {
+				bw.WriteString("- " + d + "\n")
+			}
+		}
+	}
+
+	bw.WriteString("\n## " + t.Story + "\n")
+	for _, e := range s.Entries {
+		bw.WriteString("\n")
+		if e.Player != "" {
+			// player input is quoted to set it apart from the narration
+			bw.WriteString("> **" + e.Player + ":** " + strings.Join(e.Paragraphs, "\n>\n> ") + "\n")
+		} else {
+			bw.WriteString(strings.Join(e.Paragraphs, "\n\n") + "\n")
+		}
+		if e.Roll != nil {
+			bw.WriteString("\n*🎲 " + e.Roll.Text + " – " + e.Roll.Outcome + "*\n")
+		}
+		for _, a := range e.Audio {
+			bw.WriteString("\n[" + t.Audio + "](" + a + ")\n")
+		}
+	}
+
+	if s.Appendix != nil {
+		bw.WriteString("\n## " + t.Appendix + "\n")
+		if len(s.Appendix.Events) > 0 {
+			bw.WriteString("\n### " + t.Events + "\n\n")
+			for _, event := range s.Appendix.Events {
+				bw.WriteString("- " + event + "\n")
+			}
+		}
+		if len(s.Appendix.Entities) > 0 {
+			bw.WriteString("\n### " + t.Entities + "\n")
+			for _, e := range s.Appendix.Entities {
+				bw.WriteString("\n#### " + e.Name + "\n\n")
+				for _, d := range e.Data {
+					bw.WriteString("- " + d + "\n")
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -3,13 +3,22 @@ package games
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"gameslabor/internal/ai"
+	"gameslabor/internal/config"
 	"gameslabor/internal/games/scenarios"
 	"gameslabor/internal/karmicdice"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/logging"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/prompts"
 	"gameslabor/internal/server/hub"
-	"log"
+	"log/slog"
+	"maps"
+	"slices"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -21,12 +30,45 @@ type (
 		Players        map[string]*Player `json:"players"`
 		mut            sync.Mutex         `json:"-"`
 		Roll           *DiceRoll          `json:"roll"`
+		Dice           karmicdice.Dice    `json:"dice"`
 		State          GameState          `json:"state"`
 		AcceptingInput bool               `json:"accepting_input"`
+		Settings       Settings           `json:"settings"`
+		Turn           int                `json:"turn"`
+		// Host is the player who created the game, only they can create
+		// save points and fork the campaign.
+		Host       string          `json:"host"`
+		SavePoints []SavePointInfo `json:"save_points"`
+		// Lineage is set on games forked from a save point.
+		Lineage *Lineage    `json:"lineage,omitempty"`
+		media   *mediaQueue `json:"-"`
+		// turn is held by the actions that let the AI answer, before mut.
+		// mut is released while the AI waits for the LLM, see ask.
+		turn sync.Mutex `json:"-"`
 	}
 
 	GameState uint8
 
+	Settings struct {
+		Scenario string `json:"scenario"`
+		// CustomScenario is written by the players in the lobby.
+		// It is used instead of Scenario if set.
+		CustomScenario *scenarios.Scenario `json:"custom_scenario,omitempty"`
+		ViolenceLevel  uint8               `json:"violence_level"`
+		Duration       uint8               `json:"duration"`
+		// TTSBackend selects the speech synthesis of this game.
+		// Empty uses the server default.
+		TTSBackend string `json:"tts_backend"`
+		// Language is the code of the campaign language, see locale.Get.
+		// Empty uses the default language.
+		Language string `json:"language"`
+		// Models are the model and sampling settings of the AI.
+		// Empty fields use the server config.
+		Models ai.ModelSettings `json:"models"`
+		// Boundaries are the lines and veils the players set in the lobby.
+		Boundaries ai.Boundaries `json:"boundaries"`
+	}
+
 	PlayerData struct {
 		Name       string `json:"name"`
 		Age        string `json:"age"`
@@ -40,10 +82,7 @@ type (
 	}
 )
 
-type DiceRoll struct {
-	Difficulty uint8 `json:"difficulty"`
-	Result     uint8 `json:"result"`
-}
+type DiceRoll = ai.DiceRoll
 
 type (
 	WsFullOverwrite struct {
@@ -55,9 +94,75 @@ type (
 		Path   string `json:"path"`
 		Value  any    `json:"value"`
 	}
+	WsNotice struct {
+		Method  string `json:"method"`
+		Message string `json:"message"`
+	}
 )
 
-var Games = make(map[string]*Game)
+var (
+	ErrGameNotFound      = errors.New("game not found")
+	ErrNotInit           = errors.New("game state is not init")
+	ErrNotRunning        = errors.New("game state is not running")
+	ErrNotAcceptingInput = errors.New("game is not accepting input")
+	ErrNoRoll            = errors.New("no roll to continue after")
+	ErrUnknownPlayer     = errors.New("player is not part of this game")
+	ErrInvalidScenario   = errors.New("invalid scenario")
+	ErrAIUnavailable     = errors.New("failed to create AI")
+	ErrEmptyInput        = errors.New("input is empty")
+	ErrInvalidTTSBackend = errors.New("invalid tts backend")
+	ErrInvalidLanguage   = errors.New("invalid language")
+	ErrNotHost           = errors.New("player is not the host of this game")
+	ErrNoNarration       = errors.New("no message of the narrator to rewrite")
+	ErrInputTooLong      = errors.New("input is too long")
+	ErrRateLimited       = errors.New("too many inputs")
+	ErrGameExists        = errors.New("a game with this id exists already")
+)
+
+var (
+	Games    = make(map[string]*Game)
+	gamesMut sync.RWMutex
+
+	// cfg is passed to the AI of every game, see SetConfig.
+	cfg = config.Default()
+)
+
+// SetConfig sets the configuration the AI of every game is created with.
+// It has to be called before games are loaded or started.
+func SetConfig(c *config.Config) {
+	cfg = c
+}
+
+// TTSBackends returns the TTS backends a game can be started with.
+func TTSBackends() []string {
+	return ai.Speakers(cfg)
+}
+
+// Get returns the game with the given id or ErrGameNotFound.
+func Get(id string) (*Game, error) {
+	gamesMut.RLock()
+	defer gamesMut.RUnlock()
+
+	if g, ok := Games[id]; ok {
+		return g, nil
+	}
+	return nil, ErrGameNotFound
+}
+
+// List returns all known games sorted by id.
+func List() []*Game {
+	gamesMut.RLock()
+	defer gamesMut.RUnlock()
+
+	list := make([]*Game, 0, len(Games))
+	for _, g := range Games {
+		list = append(list, g)
+	}
+	slices.SortFunc(list, func(a, b *Game) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return list
+}
 
 func New() *Game {
 	return newWithId(uuid.NewString())
@@ -65,15 +170,15 @@ func New() *Game {
 
 func newWithId(id string) *Game {
 	game := &Game{
-		id,
-		ai.Empty(),
-		make(map[string]*Player),
-		sync.Mutex{},
-		nil,
-		GameStateInit,
-		false,
+		ID:      id,
+		AI:      ai.Empty(),
+		Players: make(map[string]*Player),
+		State:   GameStateInit,
 	}
+	gamesMut.Lock()
 	Games[id] = game
+	gamesMut.Unlock()
+	metrics.ActiveGames.WithLabelValues(game.State.String()).Inc()
 	return game
 }
 
@@ -85,30 +190,201 @@ func (g *Game) AddPlayer(playerID string) {
 		return
 	}
 	g.Players[playerID] = &Player{ID: playerID}
+	if g.Host == "" {
+		g.Host = playerID
+	}
+}
+
+// isHost reports whether the player may manage the campaign. Games saved
+// before there were hosts let every player do that.
+func (g *Game) isHost(playerID string) bool {
+	if g.Host == "" {
+		_, ok := g.Players[playerID]
+		return ok
+	}
+	return g.Host == playerID
+}
+
+// Locale returns the texts of the language of the campaign.
+func (g *Game) Locale() *locale.Locale {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return locale.GetOrDefault(g.Settings.Language)
+}
+
+// IsHost is isHost for callers that don't hold g.mut.
+func (g *Game) IsHost(playerID string) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return g.isHost(playerID)
+}
+
+func (g *Game) HasPlayer(playerID string) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	_, ok := g.Players[playerID]
+	return ok
+}
+
+// Create creates a game with the settings, playerID becomes its first player
+// and host. Invalid settings create no game.
+func Create(ctx context.Context, playerID string, settings Settings) (*Game, error) {
+	if err := begin(); err != nil {
+		return nil, err
+	}
+	defer end()
+
+	settings, err := settings.validate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g := New()
+	g.AddPlayer(playerID)
+	g.mut.Lock()
+	g.Settings = settings
+	g.mut.Unlock()
+	slog.InfoContext(ctx, "game created", "game_id", g.ID, "scenario", settings.Scenario)
+	return g, nil
+}
+
+// validate checks the settings before they are stored on a game and returns
+// them with normalized boundaries.
+func (s Settings) validate(ctx context.Context) (Settings, error) {
+	if err := ai.ValidateModelSettings(ctx, cfg, s.Models); err != nil {
+		return Settings{}, err
+	}
+	if s.TTSBackend != "" && !ai.HasSpeaker(cfg, s.TTSBackend) {
+		return Settings{}, ErrInvalidTTSBackend
+	}
+	if _, err := locale.Get(s.Language); err != nil {
+		return Settings{}, errors.Join(ErrInvalidLanguage, err)
+	}
+	if s.Scenario != "" || s.CustomScenario != nil {
+		if _, err := s.ResolveScenario(); err != nil {
+			return Settings{}, err
+		}
+	}
+	boundaries, err := s.Boundaries.Normalize()
+	if err != nil {
+		return Settings{}, err
+	}
+	s.Boundaries = boundaries
+	return s, nil
 }
 
-func (g *Game) SetPlayerDescription(p Player) {
+// SetSettings replaces the settings used by the next Start call.
+func (g *Game) SetSettings(ctx context.Context, settings Settings) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	// validated before locking, listing the models may take a moment
+	settings, err := settings.validate(ctx)
+	if err != nil {
+		return err
+	}
+
 	g.mut.Lock()
 	defer g.mut.Unlock()
 
-	if player, ok := g.Players[p.ID]; ok {
-		*player = p
-		hub.Broadcast(g.ID, WsSetOrPush{"set", "players." + p.ID, p})
+	if g.State != GameStateInit {
+		return ErrNotInit
 	}
+	g.Settings = settings
+	slog.InfoContext(ctx, "settings changed", "game_id", g.ID, "scenario", settings.Scenario)
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "settings", settings})
+	return nil
 }
 
-func (g *Game) PlayerInput(playerID string, input string) {
+// SetBoundaries replaces the lines and veils of the table, every player can
+// change them before the campaign starts.
+func (g *Game) SetBoundaries(ctx context.Context, playerID string, boundaries ai.Boundaries) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	boundaries, err := boundaries.Normalize()
+	if err != nil {
+		return err
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if g.State != GameStateInit {
+		return ErrNotInit
+	}
+	if _, ok := g.Players[playerID]; !ok {
+		return ErrUnknownPlayer
+	}
+	g.Settings.Boundaries = boundaries
+	slog.InfoContext(ctx, "boundaries changed", "game_id", g.ID, "lines", len(boundaries.Lines), "veils", len(boundaries.Veils))
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "settings.boundaries", boundaries})
+	return nil
+}
+
+func (g *Game) SetPlayerDescription(ctx context.Context, p Player) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	player, ok := g.Players[p.ID]
+	if !ok {
+		return ErrUnknownPlayer
+	}
+	*player = p
+	slog.InfoContext(ctx, "player description changed", "game_id", g.ID)
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "players." + p.ID, p})
+	return nil
+}
+
+func (g *Game) PlayerInput(ctx context.Context, playerID string, input string) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	g.turn.Lock()
+	defer g.turn.Unlock()
 	g.mut.Lock()
 	defer g.mut.Unlock()
 
 	if g.State != GameStateRunning {
-		log.Printf("game state is not running, can't player input\n")
-		return
+		return ErrNotRunning
 	}
 
 	if !g.AcceptingInput {
-		log.Printf("accepting input is not enabled, can't player input\n")
-		return
+		return ErrNotAcceptingInput
+	}
+
+	if _, ok := g.Players[playerID]; !ok {
+		return ErrUnknownPlayer
+	}
+
+	input, err := checkInput(playerID, input)
+	if err != nil {
+		return err
+	}
+
+	name, prompt, err := InputPrompt(g.AI, g.Players[playerID].Prompt(), input)
+	if err != nil {
+		return err
+	}
+
+	ctx = logging.With(ctx, "game_id", g.ID)
+	slog.InfoContext(ctx, "player input", "input_length", len(input))
+	if name == prompts.OutOfCharacter {
+		metrics.InputsFlagged.WithLabelValues("out_of_character").Inc()
+		slog.WarnContext(ctx, "out-of-character input, answering in character", "player_id", playerID)
 	}
 
 	g.AcceptingInput = false
@@ -120,18 +396,72 @@ func (g *Game) PlayerInput(playerID string, input string) {
 		hub.Broadcast(g.ID, WsSetOrPush{"push", "ai.chat_history", newChatMessage})
 	}
 
-	g.continueWithPrompt(fmt.Sprintf(`Führe die Geschichte nach dem Input von Spieler %s weiter.`, playerID))
-	go g.addAllMissingAudio()
+	g.continueWithPrompt(ctx, prompt)
+	g.queueMissingMedia(ctx)
+	return nil
+}
+
+// Transcribe turns a recording of a player into text. The text is not used
+// as input yet, the player confirms it first and sends it with PlayerInput.
+func (g *Game) Transcribe(ctx context.Context, playerID string, audio []byte, mimeType string) (string, error) {
+	if err := begin(); err != nil {
+		return "", err
+	}
+	defer end()
+
+	g.mut.Lock()
+	running := g.State == GameStateRunning
+	_, isPlayer := g.Players[playerID]
+	language := locale.GetOrDefault(g.Settings.Language)
+	g.mut.Unlock()
+
+	if !running {
+		return "", ErrNotRunning
+	}
+	if !isPlayer {
+		return "", ErrUnknownPlayer
+	}
+	if len(audio) == 0 {
+		return "", ErrEmptyInput
+	}
+
+	// the lock is not held while transcribing, other players can keep playing
+	ctx = logging.With(ctx, "game_id", g.ID)
+	return ai.Transcribe(ctx, cfg, audio, mimeType, language)
 }
 
-func (g *Game) continueWithPrompt(processingPrompt string) {
-	resp := g.AI.Continue(processingPrompt)
-	newChatMessage := ai.ChatMessage{Role: "model", Message: resp.NarratorText}
+func (g *Game) continueWithPrompt(ctx context.Context, processingPrompt string) {
+	g.Turn++
+	ctx = logging.With(ctx, "turn", g.Turn)
+	resp := g.ask(ctx, g.AI.Continue, processingPrompt)
+	newChatMessage := resp.ChatMessage()
 	g.AI.ChatHistory = append(g.AI.ChatHistory, newChatMessage)
 	hub.Broadcast(g.ID, WsSetOrPush{"push", "ai.chat_history", newChatMessage})
+	g.setRoll(ctx, resp)
+}
+
+// ask lets the AI answer prompt with answer, g.AI.Start or g.AI.Continue.
+// g.mut is released while the AI waits for the LLM, so the game can be viewed
+// in the meantime. Shutdown cancels it if it takes too long. The caller holds
+// g.turn and g.mut.
+func (g *Game) ask(ctx context.Context, answer func(context.Context, string) ai.ResponseSchema, prompt string) ai.ResponseSchema {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(running, cancel)
+	defer stop()
+
+	g.AI.SetLocker(&g.mut)
+	defer g.AI.SetLocker(nil)
+	return answer(ctx, prompt)
+}
+
+// setRoll rolls the dice the response asks for, without a roll the players
+// can answer.
+func (g *Game) setRoll(ctx context.Context, resp ai.ResponseSchema) {
 	if resp.RollDice != nil {
-		r := karmicdice.Int(resp.RollDice.Difficulty)
+		r := g.Dice.Int(resp.RollDice.Difficulty)
 		g.Roll = &DiceRoll{Difficulty: uint8(resp.RollDice.Difficulty), Result: uint8(r)}
+		slog.InfoContext(ctx, "dice roll requested", "difficulty", g.Roll.Difficulty, "result", g.Roll.Result)
 	} else {
 		g.Roll = nil
 		g.AcceptingInput = true
@@ -140,6 +470,100 @@ func (g *Game) continueWithPrompt(processingPrompt string) {
 	hub.Broadcast(g.ID, WsSetOrPush{"set", "roll", g.Roll})
 }
 
+// XCard lets a player reject the last message of the narrator without
+// explaining why. It is written again without the content, which is avoided
+// from then on. topic may name what to avoid.
+func (g *Game) XCard(ctx context.Context, playerID string, topic string) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	g.turn.Lock()
+	defer g.turn.Unlock()
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if g.State != GameStateRunning {
+		return ErrNotRunning
+	}
+	if _, ok := g.Players[playerID]; !ok {
+		return ErrUnknownPlayer
+	}
+	i := len(g.AI.ChatHistory) - 1
+	if i < 0 || g.AI.ChatHistory[i].Role != "model" {
+		return ErrNoNarration
+	}
+	rejected := g.AI.ChatHistory[i]
+
+	topic = ai.SanitizeInput(topic)
+	if length := utf8.RuneCountInString(topic); length > cfg.Input.MaxLength {
+		return fmt.Errorf("%w: %d characters, at most %d", ErrInputTooLong, length, cfg.Input.MaxLength)
+	}
+	prompt, err := g.AI.Prompt(prompts.XCard, prompts.XCardData{Topic: topic, Message: rejected.Message})
+	if err != nil {
+		return err
+	}
+
+	ctx = logging.With(ctx, "game_id", g.ID)
+	// the player is not logged, the X-card is anonymous
+	slog.InfoContext(ctx, "x-card played", "chat_index", i, "topic", topic != "")
+
+	g.AI.XCard(topic, rejected.Message)
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "ai.boundaries", g.AI.Boundaries})
+	g.AcceptingInput = false
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "accepting_input", false})
+
+	// the audio and image of the rejected message may still be generated
+	g.stopMedia()
+
+	// the rejected message is only part of the prompt, not of the history,
+	// and what it added to the memory is forgotten
+	g.AI.ChatHistory = g.AI.ChatHistory[:i]
+	g.AI.RevertLastResponse()
+	resp := g.ask(ctx, g.AI.Continue, prompt)
+	newChatMessage := resp.ChatMessage()
+	g.AI.ChatHistory = append(g.AI.ChatHistory, newChatMessage)
+	hub.Broadcast(g.ID, WsSetOrPush{"set", fmt.Sprintf("ai.chat_history.%d", i), newChatMessage})
+	g.setRoll(ctx, resp)
+	g.queueMissingMedia(ctx)
+	return nil
+}
+
+// ResolveScenario returns the custom scenario of the settings if there is one,
+// the built-in or loaded scenario with the id otherwise.
+func (s Settings) ResolveScenario() (scenarios.Scenario, error) {
+	if s.CustomScenario != nil {
+		custom := *s.CustomScenario
+		custom.Builtin = false
+		if err := custom.Validate(); err != nil {
+			return scenarios.Scenario{}, errors.Join(ErrInvalidScenario, err)
+		}
+		return custom, nil
+	}
+	scenario, err := scenarios.Get(s.Scenario, s.Language)
+	if err != nil {
+		return scenarios.Scenario{}, errors.Join(ErrInvalidScenario, err)
+	}
+	return scenario, nil
+}
+
+// StartData returns the data of the start prompt for the scenario of the
+// settings, see Settings.ResolveScenario.
+func (s Settings) StartData(scenario scenarios.Scenario, language *locale.Locale, players map[string]*Player) prompts.StartData {
+	data := prompts.StartData{
+		Scenario:          scenario.Text(language),
+		ViolenceLevel:     s.ViolenceLevel,
+		ViolenceLevelText: language.ViolenceLevel(s.ViolenceLevel),
+		Duration:          s.Duration,
+		DurationText:      language.Duration(s.Duration),
+	}
+	for _, id := range slices.Sorted(maps.Keys(players)) {
+		data.Players = append(data.Players, players[id].Prompt())
+	}
+	return data
+}
+
 func clamp[T cmp.Ordered](min, v, max T) T {
 	if v < min {
 		return min
@@ -150,45 +574,101 @@ func clamp[T cmp.Ordered](min, v, max T) T {
 	return v
 }
 
-func (g *Game) Start(scenario string, violenceLevel uint8, duration uint8) {
+// NewAI creates the AI of a campaign with the memory the scenario and the
+// players start with and renders the start prompt for it. Start uses it, and
+// so can commands that play a campaign without a game, e.g. cmd/eval.
+func NewAI(ctx context.Context, gameID string, settings Settings, players map[string]*Player) (*ai.AI, string, error) {
+	language, err := locale.Get(settings.Language)
+	if err != nil {
+		return nil, "", errors.Join(ErrInvalidLanguage, err)
+	}
+	scenario, err := settings.ResolveScenario()
+	if err != nil {
+		return nil, "", err
+	}
+	prompt, err := prompts.Render(language.Code, prompts.Start, settings.StartData(scenario, language, players))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if settings.TTSBackend != "" && !ai.HasSpeaker(cfg, settings.TTSBackend) {
+		return nil, "", ErrInvalidTTSBackend
+	}
+	if err := ai.ValidateModelSettings(ctx, cfg, settings.Models); err != nil {
+		return nil, "", err
+	}
+	boundaries, err := settings.Boundaries.Normalize()
+	if err != nil {
+		return nil, "", err
+	}
+
+	newAI, err := ai.New(ctx, cfg, gameID, settings.TTSBackend, language.Code)
+	if err != nil {
+		return nil, "", errors.Join(ErrAIUnavailable, err)
+	}
+	newAI.Models = settings.Models
+	newAI.Boundaries = boundaries
+
+	for name, data := range scenario.EntityData(language) {
+		newAI.EntityData[name] = data
+	}
+	newAI.EventPlan = append(newAI.EventPlan, scenario.EventPlan(language)...)
+	for _, player := range players {
+		newAI.EntityData["player_"+player.ID] = player.Description.Slice(language)
+	}
+	return newAI, prompt, nil
+}
+
+// Start starts the campaign. Empty fields in settings fall back to the
+// settings stored on the game.
+func (g *Game) Start(ctx context.Context, settings Settings) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	g.turn.Lock()
+	defer g.turn.Unlock()
 	g.mut.Lock()
 	defer g.mut.Unlock()
 
 	if g.State != GameStateInit {
-		log.Printf("game state is not init, can't start game\n")
-		return
+		return ErrNotInit
 	}
 
-	ctx := context.Background()
-	s, err := scenarios.FromID(scenario)
-	if err != nil {
-		log.Printf("failed to get scenario description: %v", err)
-		return
+	if settings.Scenario == "" && settings.CustomScenario == nil {
+		settings = g.Settings
+	}
+	// the boundaries are set by all players, not by the one who starts
+	if settings.Boundaries.IsZero() {
+		settings.Boundaries = g.Settings.Boundaries
 	}
 
-	s += "\n\nZiel-Gewaltgrad: " + scenarios.ViolenceLevel(violenceLevel).String()
-	s += "\n\nZiel-Länge der gesammten Kampagne: " + scenarios.Duration(duration).String()
-
-	g.State = GameStateRunning
-	g.AcceptingInput = false
-	g.AI, err = ai.New(ctx)
+	ctx = logging.With(ctx, "game_id", g.ID)
+	newAI, prompt, err := NewAI(ctx, g.ID, settings, g.Players)
 	if err != nil {
-		log.Printf("failed to create AI: %v", err)
-		return
+		return err
 	}
+	g.AI = newAI
+	g.Settings = settings
+	metrics.ActiveGames.WithLabelValues(g.State.String()).Dec()
+	g.State = GameStateRunning
+	metrics.ActiveGames.WithLabelValues(g.State.String()).Inc()
+	g.AcceptingInput = false
 
-	for _, player := range g.Players {
-		g.AI.EntityData["player_"+player.ID] = player.Description.Slice()
-	}
-	hub.Broadcast(g.ID, WsFullOverwrite{Method: "full_overwrite", Value: g})
+	hub.Broadcast(g.ID, WsFullOverwrite{Method: "full_overwrite", Value: g.snapshot()})
 
-	resp := g.AI.Start(s)
-	newChatMessage := ai.ChatMessage{Role: "model", Message: resp.NarratorText}
+	g.Turn++
+	ctx = logging.With(ctx, "turn", g.Turn)
+	slog.InfoContext(ctx, "starting game", "scenario", settings.Scenario, "language", newAI.Language, "custom_scenario", settings.CustomScenario != nil, "players", len(g.Players))
+	resp := g.ask(ctx, g.AI.Start, prompt)
+	newChatMessage := resp.ChatMessage()
 	g.AI.ChatHistory = append(g.AI.ChatHistory, newChatMessage)
-	fmt.Printf("First message: %s\n", resp.JSON())
+	slog.DebugContext(ctx, "first message", "response", resp.JSON())
 	if resp.RollDice != nil {
-		r := karmicdice.Int(resp.RollDice.Difficulty)
+		r := g.Dice.Int(resp.RollDice.Difficulty)
 		g.Roll = &DiceRoll{Difficulty: uint8(resp.RollDice.Difficulty), Result: uint8(r)}
+		slog.InfoContext(ctx, "dice roll requested", "difficulty", g.Roll.Difficulty, "result", g.Roll.Result)
 	}
 	hub.Broadcast(g.ID, WsSetOrPush{"push", "ai.chat_history", newChatMessage})
 	hub.Broadcast(g.ID, WsSetOrPush{"set", "roll", g.Roll})
@@ -196,56 +676,53 @@ func (g *Game) Start(scenario string, violenceLevel uint8, duration uint8) {
 	g.AcceptingInput = true
 	hub.Broadcast(g.ID, WsSetOrPush{"set", "accepting_input", true})
 
-	go g.addAllMissingAudio()
+	g.queueMissingMedia(ctx)
+	return nil
 }
 
-func (g *Game) ContinueAfterRoll() {
+func (g *Game) ContinueAfterRoll(ctx context.Context) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	g.turn.Lock()
+	defer g.turn.Unlock()
 	g.mut.Lock()
 	defer g.mut.Unlock()
 
 	if g.State != GameStateRunning {
-		log.Printf("game state is not running, can't continue after roll\n")
-		return
+		return ErrNotRunning
 	}
 
 	if g.Roll == nil {
-		log.Printf("no roll, can't continue after roll\n")
-		return
+		return ErrNoRoll
 	}
 
-	hub.Broadcast(g.ID, WsSetOrPush{"set", "roll", nil})
+	ctx = logging.With(ctx, "game_id", g.ID)
+	slog.InfoContext(ctx, "continue after roll", "difficulty", g.Roll.Difficulty, "result", g.Roll.Result)
 
+	name := prompts.RollFailure
 	if g.Roll.Result >= g.Roll.Difficulty {
-		g.continueWithPrompt(fmt.Sprintf("Es wurde eine %d von %d gewürfelt, der Roll ist damit erfolgreich. Führe die Geschichte fort.", g.Roll.Result, g.Roll.Difficulty))
-	} else {
-		g.continueWithPrompt(fmt.Sprintf("Es wurde eine %d von %d gewürfelt, der Roll ist damit fehlgeschlagen. Führe die Geschichte fort.", g.Roll.Result, g.Roll.Difficulty))
+		name = prompts.RollSuccess
+	}
+	prompt, err := g.AI.Prompt(name, prompts.RollData{Result: g.Roll.Result, Difficulty: g.Roll.Difficulty})
+	if err != nil {
+		return err
 	}
-	go g.addAllMissingAudio()
-}
-
-func (g *Game) addAllMissingAudio() {
-	g.mut.Lock()
-	defer g.mut.Unlock()
 
-	for i, m := range g.AI.ChatHistory {
-		if len(m.Audio) > 0 || m.Role != "model" {
-			continue
-		}
-		if audio, err := g.AI.TTS(m.Message); err != nil {
-			fmt.Println("error during tts:", err.Error())
-			continue
-		} else {
-			g.AI.ChatHistory[i].Audio = audio
-			hub.Broadcast(
-				g.ID,
-				WsSetOrPush{
-					"set",
-					fmt.Sprintf("ai.chat_history.%d.audio", i),
-					audio,
-				},
-			)
-		}
+	// remember the roll with the message that asked for it, e.g. for exports
+	if i := len(g.AI.ChatHistory) - 1; i >= 0 && g.AI.ChatHistory[i].Role == "model" {
+		roll := *g.Roll
+		g.AI.ChatHistory[i].Roll = &roll
+		hub.Broadcast(g.ID, WsSetOrPush{"set", fmt.Sprintf("ai.chat_history.%d.roll", i), &roll})
 	}
+	g.Roll = nil
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "roll", nil})
+
+	g.continueWithPrompt(ctx, prompt)
+	g.queueMissingMedia(ctx)
+	return nil
 }
 
 func init() {
@@ -272,11 +749,35 @@ const (
 	GameStateRunning
 )
 
-func (pd PlayerData) Slice() []string {
+func (s GameState) String() string {
+	switch s {
+	case GameStateInit:
+		return "init"
+	case GameStateRunning:
+		return "running"
+	default:
+		return "unknown"
+	}
+}
+
+// Slice returns the description in the format of ai.AI.EntityData, with the
+// field names in the given language.
+func (pd PlayerData) Slice(l *locale.Locale) []string {
 	return []string{
-		"name: " + pd.Name,
-		"alter: " + pd.Age,
-		"aussehen: " + pd.Appearance,
-		"herkunft: " + pd.Origin,
+		l.PlayerFields.Name + ": " + pd.Name,
+		l.PlayerFields.Age + ": " + pd.Age,
+		l.PlayerFields.Appearance + ": " + pd.Appearance,
+		l.PlayerFields.Origin + ": " + pd.Origin,
+	}
+}
+
+// Prompt returns the player in the format of the prompt templates.
+func (p *Player) Prompt() prompts.Player {
+	return prompts.Player{
+		ID:         p.ID,
+		Name:       p.Description.Name,
+		Age:        p.Description.Age,
+		Origin:     p.Description.Origin,
+		Appearance: p.Description.Appearance,
 	}
 }
@@ -0,0 +1,91 @@
+package games
+
+import (
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/prompts"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// inputs counts the inputs of every player over all games for the rate
+// limit of the config.
+var inputs = inputLimiter{sent: make(map[string][]time.Time)}
+
+type inputLimiter struct {
+	mut sync.Mutex
+	// sent are the times of the inputs of the last minute by player.
+	sent map[string][]time.Time
+}
+
+// allow records an input of the player and reports whether it is one of at
+// most perMinute inputs in the last minute. Rejected inputs are not recorded,
+// so a player who waits can send again. perMinute 0 allows everything.
+func (l *inputLimiter) allow(playerID string, perMinute int, now time.Time) bool {
+	if perMinute <= 0 {
+		return true
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	// forget the inputs older than a minute of all players, so players who
+	// left don't stay in the map
+	since := now.Add(-time.Minute)
+	for id, times := range l.sent {
+		i := 0
+		for i < len(times) && !times[i].After(since) {
+			i++
+		}
+		if i == len(times) {
+			delete(l.sent, id)
+		} else {
+			l.sent[id] = times[i:]
+		}
+	}
+
+	if len(l.sent[playerID]) >= perMinute {
+		return false
+	}
+	l.sent[playerID] = append(l.sent[playerID], now)
+	return true
+}
+
+// checkInput returns the sanitized input of a player, see ai.SanitizeInput,
+// or an error if it is empty, too long or over the rate limit of the player.
+// Only valid inputs count for the rate limit.
+func checkInput(playerID string, input string) (string, error) {
+	input = ai.SanitizeInput(input)
+	if input == "" {
+		return "", ErrEmptyInput
+	}
+	if length := utf8.RuneCountInString(input); length > cfg.Input.MaxLength {
+		metrics.InputsFlagged.WithLabelValues("too_long").Inc()
+		return "", fmt.Errorf("%w: %d characters, at most %d", ErrInputTooLong, length, cfg.Input.MaxLength)
+	}
+	if !inputs.allow(playerID, cfg.Input.PerMinute, time.Now()) {
+		metrics.InputsFlagged.WithLabelValues("rate_limited").Inc()
+		return "", fmt.Errorf("%w: at most %d inputs per minute", ErrRateLimited, cfg.Input.PerMinute)
+	}
+	return input, nil
+}
+
+// InputTemplate returns the name of the prompt template that continues the
+// story after input. An input that talks to the LLM instead of the narrator,
+// see ai.OutOfCharacter, is answered in character with
+// prompts.OutOfCharacter.
+func InputTemplate(input string) string {
+	if ai.OutOfCharacter(input) != "" {
+		return prompts.OutOfCharacter
+	}
+	return prompts.PlayerInput
+}
+
+// InputPrompt renders the prompt that continues the story after the input of
+// player and returns it with the name of its template, see InputTemplate.
+func InputPrompt(a *ai.AI, player prompts.Player, input string) (string, string, error) {
+	name := InputTemplate(input)
+	prompt, err := a.Prompt(name, prompts.PlayerInputData{Player: player, Input: input})
+	return name, prompt, err
+}
@@ -0,0 +1,279 @@
+package games
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/server/hub"
+	"log/slog"
+)
+
+const (
+	// mediaWorkers is the number of audio files and images of one game that
+	// are generated at the same time.
+	mediaWorkers = 3
+	// mediaQueueSize is the number of jobs that can wait for a worker.
+	// Jobs that don't fit are queued again after the next turn.
+	mediaQueueSize = 64
+)
+
+type (
+	// mediaQueue generates the audio and images of the chat messages of a
+	// game in the background, so the game lock is only held to read the
+	// message and store the result.
+	mediaQueue struct {
+		jobs chan mediaJob
+		// pending are the jobs that are queued or in progress.
+		// It is guarded by Game.mut.
+		pending map[mediaKey]bool
+		ctx     context.Context
+		cancel  context.CancelFunc
+	}
+
+	mediaKey struct {
+		index int
+		kind  mediaKind
+	}
+
+	mediaKind uint8
+
+	mediaJob struct {
+		// ctx carries the log attributes of the turn the message belongs to.
+		ctx context.Context
+		mediaKey
+	}
+)
+
+const (
+	mediaAudio mediaKind = iota
+	mediaImage
+)
+
+// queueMissingMedia queues every model message without audio or with a
+// scene but without image, unless the game used up its asset quota.
+// g.mut must be held.
+func (g *Game) queueMissingMedia(ctx context.Context) {
+	if g.AI.AssetsFull() {
+		return
+	}
+	if g.media == nil {
+		q := &mediaQueue{
+			jobs:    make(chan mediaJob, mediaQueueSize),
+			pending: make(map[mediaKey]bool),
+		}
+		q.ctx, q.cancel = context.WithCancel(running)
+		for range mediaWorkers {
+			go g.mediaWorker(q)
+		}
+		g.media = q
+	}
+
+	for i, m := range g.AI.ChatHistory {
+		if m.Role != "model" {
+			continue
+		}
+		if m.Scene != "" && m.Image == "" && g.AI.CanIllustrate() {
+			if !g.queueMedia(ctx, mediaKey{i, mediaImage}) {
+				return
+			}
+		}
+		if m.Audio == "" && len(m.AudioSegments) == 0 {
+			if !g.queueMedia(ctx, mediaKey{i, mediaAudio}) {
+				return
+			}
+		}
+	}
+}
+
+// queueMedia returns false if the queue is full.
+func (g *Game) queueMedia(ctx context.Context, key mediaKey) bool {
+	if g.media.pending[key] {
+		return true
+	}
+	select {
+	case g.media.jobs <- mediaJob{ctx, key}:
+		g.media.pending[key] = true
+		if key.kind == mediaAudio {
+			metrics.TTSPending.Inc()
+		}
+		return true
+	default:
+		slog.WarnContext(ctx, "media queue is full", "game_id", g.ID, "chat_index", key.index)
+		return false
+	}
+}
+
+// stopMedia cancels all queued and running TTS and image requests of the game.
+// g.mut must be held.
+func (g *Game) stopMedia() {
+	if g.media == nil {
+		return
+	}
+	g.media.cancel()
+	for key := range g.media.pending {
+		if key.kind == mediaAudio {
+			metrics.TTSPending.Dec()
+		}
+	}
+	clear(g.media.pending)
+	g.media = nil
+}
+
+func (g *Game) mediaWorker(q *mediaQueue) {
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case job := <-q.jobs:
+			g.generateMedia(q, job)
+		}
+	}
+}
+
+func (g *Game) generateMedia(q *mediaQueue, job mediaJob) {
+	defer g.mediaDone(q, job.mediaKey)
+
+	if err := begin(); err != nil {
+		return
+	}
+	defer end()
+
+	ctx, cancel := context.WithCancel(job.ctx)
+	defer cancel()
+	stop := context.AfterFunc(q.ctx, cancel)
+	defer stop()
+
+	switch job.kind {
+	case mediaAudio:
+		g.synthesizeAudio(ctx, job.index)
+	case mediaImage:
+		g.illustrate(ctx, job.index)
+	}
+}
+
+func (g *Game) synthesizeAudio(ctx context.Context, index int) {
+	g.mut.Lock()
+	gameAI := g.AI
+	if index >= len(gameAI.ChatHistory) {
+		g.mut.Unlock()
+		return
+	}
+	m := gameAI.ChatHistory[index]
+	// voices are assigned under the lock, because that changes the AI
+	voices := make([]string, len(m.Segments))
+	for i, segment := range m.Segments {
+		voices[i] = gameAI.Voice(segment.Speaker)
+	}
+	g.mut.Unlock()
+
+	var (
+		audio         string
+		audioSegments []string
+		err           error
+	)
+	// a message spoken by several voices only has the audio of its segments
+	if len(m.Segments) > 0 {
+		audioSegments, err = segmentsAudio(ctx, gameAI, m.Segments, voices)
+	} else {
+		audio, err = gameAI.TTS(ctx, m.Spoken(), "")
+	}
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "error during tts", "game_id", g.ID, "chat_index", index, "err", err)
+		}
+		return
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	// the campaign may have been replaced while the lock was released
+	if g.AI != gameAI || index >= len(g.AI.ChatHistory) || g.AI.ChatHistory[index].Message != m.Message {
+		return
+	}
+	if audioSegments != nil {
+		g.AI.ChatHistory[index].AudioSegments = audioSegments
+		hub.Broadcast(
+			g.ID,
+			WsSetOrPush{
+				"set",
+				fmt.Sprintf("ai.chat_history.%d.audio_segments", index),
+				audioSegments,
+			},
+		)
+		return
+	}
+	g.AI.ChatHistory[index].Audio = audio
+	hub.Broadcast(
+		g.ID,
+		WsSetOrPush{
+			"set",
+			fmt.Sprintf("ai.chat_history.%d.audio", index),
+			audio,
+		},
+	)
+}
+
+func (g *Game) illustrate(ctx context.Context, index int) {
+	g.mut.Lock()
+	gameAI := g.AI
+	if index >= len(gameAI.ChatHistory) {
+		g.mut.Unlock()
+		return
+	}
+	m := gameAI.ChatHistory[index]
+	g.mut.Unlock()
+
+	image, err := gameAI.Illustrate(ctx, m.Scene)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "error during illustration", "game_id", g.ID, "chat_index", index, "err", err)
+		}
+		return
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	// the campaign may have been replaced while the lock was released
+	if g.AI != gameAI || index >= len(g.AI.ChatHistory) || g.AI.ChatHistory[index].Scene != m.Scene {
+		return
+	}
+	g.AI.ChatHistory[index].Image = image
+	hub.Broadcast(
+		g.ID,
+		WsSetOrPush{
+			"set",
+			fmt.Sprintf("ai.chat_history.%d.image", index),
+			image,
+		},
+	)
+}
+
+func (g *Game) mediaDone(q *mediaQueue, key mediaKey) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if q.pending[key] {
+		delete(q.pending, key)
+		if key.kind == mediaAudio {
+			metrics.TTSPending.Dec()
+		}
+	}
+}
+
+// segmentsAudio synthesizes every segment with the voice of its speaker.
+// The result is a playlist that is played in order.
+func segmentsAudio(ctx context.Context, gameAI *ai.AI, segments []ai.SpeechSegment, voices []string) ([]string, error) {
+	audioSegments := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		audio, err := gameAI.TTS(ctx, segment.Text, voices[i])
+		if err != nil {
+			return nil, err
+		}
+		audioSegments = append(audioSegments, audio)
+	}
+	return audioSegments, nil
+}
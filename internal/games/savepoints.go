@@ -0,0 +1,288 @@
+package games
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/karmicdice"
+	"gameslabor/internal/logging"
+	"gameslabor/internal/server/hub"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxSavePointNameLength limits the names of save points.
+const maxSavePointNameLength = 100
+
+var (
+	ErrInvalidSavePoint  = errors.New("invalid save point")
+	ErrSavePointNotFound = errors.New("save point not found")
+)
+
+type (
+	// SavePointInfo describes a save point, the state itself is stored in
+	// <dir>/savepoints/<game>/<id>.json.
+	SavePointInfo struct {
+		ID         string    `json:"id"`
+		Name       string    `json:"name"`
+		Created    time.Time `json:"created"`
+		Turn       int       `json:"turn"`
+		ChatLength int       `json:"chat_length"`
+	}
+
+	// SavePoint is everything needed to continue a running game from the
+	// moment it was saved. The settings can't change while a game is running,
+	// so they are taken from the game.
+	SavePoint struct {
+		SavePointInfo
+		Roll           *DiceRoll          `json:"roll"`
+		Dice           karmicdice.Dice    `json:"dice"`
+		AcceptingInput bool               `json:"accepting_input"`
+		Players        map[string]*Player `json:"players"`
+		AI             *ai.AI             `json:"ai"`
+	}
+
+	// Lineage tells from which save point of which game a game was forked.
+	Lineage struct {
+		ParentID      string `json:"parent_id"`
+		SavePointID   string `json:"save_point_id"`
+		SavePointName string `json:"save_point_name"`
+	}
+
+	// TreeNode is a game in the branch tree of a campaign.
+	TreeNode struct {
+		ID         string      `json:"id"`
+		State      GameState   `json:"state"`
+		Turn       int         `json:"turn"`
+		ChatLength int         `json:"chat_length"`
+		Lineage    *Lineage    `json:"lineage,omitempty"`
+		Children   []*TreeNode `json:"children"`
+	}
+)
+
+func savePointDir(dir string, gameID string) string {
+	return filepath.Join(dir, "savepoints", gameID)
+}
+
+// CreateSavePoint stores the current state of a running game under name.
+// Only the host can create save points.
+func (g *Game) CreateSavePoint(ctx context.Context, dir string, playerID string, name string) (SavePointInfo, error) {
+	if err := begin(); err != nil {
+		return SavePointInfo{}, err
+	}
+	defer end()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return SavePointInfo{}, fmt.Errorf("%w: name is empty", ErrInvalidSavePoint)
+	}
+	if len(name) > maxSavePointNameLength {
+		return SavePointInfo{}, fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidSavePoint, maxSavePointNameLength)
+	}
+
+	// a save point is never taken in the middle of a turn
+	g.turn.Lock()
+	defer g.turn.Unlock()
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if !g.isHost(playerID) {
+		return SavePointInfo{}, ErrNotHost
+	}
+	if g.State != GameStateRunning {
+		return SavePointInfo{}, ErrNotRunning
+	}
+
+	sp := SavePoint{
+		SavePointInfo: SavePointInfo{
+			ID:         uuid.NewString(),
+			Name:       name,
+			Created:    time.Now().UTC(),
+			Turn:       g.Turn,
+			ChatLength: len(g.AI.ChatHistory),
+		},
+		Roll:           g.Roll,
+		Dice:           g.Dice,
+		AcceptingInput: g.AcceptingInput,
+		Players:        g.Players,
+		AI:             g.AI,
+	}
+	b, err := json.Marshal(sp)
+	if err != nil {
+		return SavePointInfo{}, err
+	}
+
+	spDir := savePointDir(dir, g.ID)
+	if err := os.MkdirAll(spDir, 0755); err != nil {
+		return SavePointInfo{}, err
+	}
+	filename := filepath.Join(spDir, sp.ID+".json")
+	tmpFilename := filename + ".tmp"
+	if err := os.WriteFile(tmpFilename, b, 0644); err != nil {
+		return SavePointInfo{}, err
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return SavePointInfo{}, err
+	}
+
+	// the index is saved with the game right away, a crash would lose it
+	g.SavePoints = append(g.SavePoints, sp.SavePointInfo)
+	if err := g.write(dir); err != nil {
+		g.SavePoints = g.SavePoints[:len(g.SavePoints)-1]
+		_ = os.Remove(filename)
+		return SavePointInfo{}, err
+	}
+	slog.InfoContext(ctx, "save point created", "game_id", g.ID, "save_point_id", sp.ID, "turn", sp.Turn)
+	hub.Broadcast(g.ID, WsSetOrPush{"set", "save_points", g.SavePoints})
+	return sp.SavePointInfo, nil
+}
+
+// Fork starts a new game from a save point of the game with gameID. The new
+// game keeps the players, the memory of the AI and the roll of the save point,
+// playerID becomes its host. Only the host of the game can fork it.
+func Fork(ctx context.Context, dir string, gameID string, savePointID string, playerID string) (*Game, error) {
+	if err := begin(); err != nil {
+		return nil, err
+	}
+	defer end()
+
+	parent, err := Get(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	parent.mut.Lock()
+	isHost := parent.isHost(playerID)
+	settings := parent.Settings
+	i := slices.IndexFunc(parent.SavePoints, func(info SavePointInfo) bool { return info.ID == savePointID })
+	parent.mut.Unlock()
+
+	if !isHost {
+		return nil, ErrNotHost
+	}
+	// only known ids are turned into file names
+	if i < 0 {
+		return nil, ErrSavePointNotFound
+	}
+
+	sp, err := ReadSavePoint(dir, gameID, savePointID)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Game{
+		ID:             uuid.NewString(),
+		AI:             sp.AI,
+		Players:        sp.Players,
+		Roll:           sp.Roll,
+		Dice:           sp.Dice,
+		State:          GameStateRunning,
+		AcceptingInput: sp.AcceptingInput,
+		Settings:       settings,
+		Turn:           sp.Turn,
+		Host:           playerID,
+		Lineage:        &Lineage{ParentID: gameID, SavePointID: sp.ID, SavePointName: sp.Name},
+	}
+	if _, ok := g.Players[playerID]; !ok {
+		g.Players[playerID] = &Player{ID: playerID}
+	}
+	g.AI.GameID = g.ID
+	// the assets are copied, so deleting one branch keeps the others intact
+	if err := g.AI.CopyAssets(g.ID); err != nil {
+		deleteAssets(g.ID)
+		return nil, err
+	}
+	if err := restore(g, false); err != nil {
+		deleteAssets(g.ID)
+		return nil, err
+	}
+
+	ctx = logging.With(ctx, "game_id", g.ID)
+	slog.InfoContext(ctx, "game forked", "parent_id", gameID, "save_point_id", sp.ID, "turn", g.Turn)
+	return g, nil
+}
+
+// ReadSavePoint reads a save point of the game with gameID from dir without
+// loading the game. Save point ids are uuids, other ids are not turned into
+// file names.
+func ReadSavePoint(dir string, gameID string, savePointID string) (*SavePoint, error) {
+	if _, err := uuid.Parse(savePointID); err != nil {
+		return nil, ErrSavePointNotFound
+	}
+	b, err := os.ReadFile(filepath.Join(savePointDir(dir, gameID), savePointID+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrSavePointNotFound
+		}
+		return nil, err
+	}
+	sp := &SavePoint{}
+	if err := json.Unmarshal(b, sp); err != nil {
+		return nil, err
+	}
+	if sp.AI == nil {
+		sp.AI = ai.Empty()
+	}
+	if sp.Players == nil {
+		sp.Players = make(map[string]*Player)
+	}
+	return sp, nil
+}
+
+// Tree returns the branch tree of the campaign the game with id belongs to,
+// starting at the oldest ancestor that still exists.
+func Tree(id string) (*TreeNode, error) {
+	g, err := Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lineage never changes after a fork, so it is read without locks
+	visited := map[string]bool{g.ID: true}
+	for g.Lineage != nil {
+		parent, err := Get(g.Lineage.ParentID)
+		if err != nil || visited[parent.ID] {
+			break
+		}
+		visited[parent.ID] = true
+		g = parent
+	}
+
+	children := make(map[string][]*Game)
+	for _, other := range List() {
+		if other.Lineage != nil {
+			children[other.Lineage.ParentID] = append(children[other.Lineage.ParentID], other)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var node func(g *Game) *TreeNode
+	node = func(g *Game) *TreeNode {
+		seen[g.ID] = true
+		g.mut.Lock()
+		n := &TreeNode{
+			ID:         g.ID,
+			State:      g.State,
+			Turn:       g.Turn,
+			ChatLength: len(g.AI.ChatHistory),
+			Lineage:    g.Lineage,
+			Children:   []*TreeNode{},
+		}
+		g.mut.Unlock()
+		for _, child := range children[g.ID] {
+			if !seen[child.ID] {
+				n.Children = append(n.Children, node(child))
+			}
+		}
+		return n
+	}
+	return node(g), nil
+}
@@ -1,72 +1,390 @@
 package scenarios
 
 import (
+	"bytes"
+	"cmp"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"gameslabor/internal/locale"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
 )
 
-//go:embed *.txt
-var fs embed.FS
+// files are the built-in scenarios in a folder per language, e.g. en/scifi.md.
+//
+//go:embed */*.md
+var files embed.FS
 
-func FromID(id string) (string, error) {
-	data, err := fs.ReadFile(id + ".txt")
-	if err != nil {
-		return "", err
+type (
+	// Scenario is the setting of a campaign. Scenario files are Markdown with
+	// the metadata as YAML front matter, the body is given to the LLM as is.
+	Scenario struct {
+		ID          string   `json:"id" yaml:"-"`
+		Title       string   `json:"title" yaml:"title"`
+		Description string   `json:"description" yaml:"description"`
+		Tone        string   `json:"tone" yaml:"tone"`
+		Themes      []string `json:"themes" yaml:"themes"`
+		NPCTypes    []string `json:"npc_types" yaml:"npc_types"`
+		Dangers     []string `json:"dangers" yaml:"dangers"`
+		// Image is the name of a public file or an URL.
+		Image string `json:"image,omitempty" yaml:"image"`
+		// Language is the code of the language the scenario is written in,
+		// scenarios without a language are shown for every language.
+		Language string `json:"language,omitempty" yaml:"language"`
+		// Dice is the recommended dice system, e.g. "d20".
+		Dice             string   `json:"dice,omitempty" yaml:"dice"`
+		StartingLocation string   `json:"starting_location,omitempty" yaml:"starting_location"`
+		NPCs             []Entity `json:"npcs,omitempty" yaml:"npcs"`
+		Factions         []Entity `json:"factions,omitempty" yaml:"factions"`
+		ContentWarnings  []string `json:"content_warnings,omitempty" yaml:"content_warnings"`
+		// Durations are the suggested values for the duration setting.
+		Durations []uint8 `json:"durations,omitempty" yaml:"durations"`
+		Body      string  `json:"body,omitempty" yaml:"-"`
+		// Builtin is set for the scenarios embedded into the binary.
+		Builtin bool `json:"builtin" yaml:"-"`
+	}
+
+	// Entity is a prebuilt NPC or faction of a scenario.
+	Entity struct {
+		Name        string `json:"name" yaml:"name"`
+		Description string `json:"description" yaml:"description"`
+	}
+)
+
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 4000
+	maxBodyLength        = 20000
+	maxListLength        = 20
+	maxListItemLength    = 200
+)
+
+var (
+	ErrNotFound = errors.New("scenario not found")
+	ErrInvalid  = errors.New("invalid scenario")
+)
+
+var frontMatterDelimiter = []byte("---")
+
+// builtinOrder is the order of the embedded scenarios in the lobby.
+var builtinOrder = []string{"scifi", "treasure_hunt", "pirates", "fantasy", "vikings", "western", "post-apocalyptic"}
+
+// builtin are the embedded scenarios by language. Languages without a folder
+// use the scenarios of the default language.
+var builtin = make(map[string][]Scenario)
+
+func init() {
+	for _, l := range locale.List() {
+		list := make([]Scenario, 0, len(builtinOrder))
+		for _, id := range builtinOrder {
+			data, err := files.ReadFile(l.Code + "/" + id + ".md")
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				panic(err)
+			}
+			s, err := Parse(id, data)
+			if err != nil {
+				panic(fmt.Errorf("built-in scenario %s/%s: %w", l.Code, id, err))
+			}
+			s.Builtin = true
+			list = append(list, s)
+		}
+		if len(list) > 0 {
+			builtin[l.Code] = list
+		}
+	}
+	if _, ok := builtin[locale.Default]; !ok {
+		panic("built-in scenarios of the default language are missing")
 	}
-	return string(data), nil
 }
 
-type (
-	violenceLevel uint8
-	duration      uint8
+func builtinOf(language string) []Scenario {
+	if list, ok := builtin[language]; ok {
+		return list
+	}
+	return builtin[locale.Default]
+}
+
+func isBuiltinID(id string) bool {
+	return slices.Contains(builtinOrder, id)
+}
+
+var (
+	// fromDir are the scenarios loaded by LoadDir by id.
+	fromDir    = make(map[string]Scenario)
+	fromDirMut sync.RWMutex
 )
 
-func ViolenceLevel(i uint8) violenceLevel {
-	if i < 0 {
-		fmt.Printf("violence level %d < 0, setting to 0", i)
-		i = 0
-	} else if i > 3 {
-		fmt.Printf("violence level %d > 3, setting to 3", i)
-		i = 3
-	}
-	return violenceLevel(i)
-}
-
-func (vl violenceLevel) String() string {
-	switch vl {
-	case 0:
-		return "Gar nicht gewalttätig"
-	case 1:
-		return "Leicht gewalttätig"
-	case 2:
-		return "Gewalttätig und grausam"
-	case 3:
-		return "Übertrieben gewalttätig, grausam und unangenehm"
-	default:
-		return "Unbekannt"
-	}
-}
-
-func Duration(i uint8) duration {
-	if i < 0 {
-		fmt.Printf("duration %d < 0, setting to 0", i)
-		i = 0
-	} else if i > 2 {
-		fmt.Printf("duration %d > 3, setting to 3", i)
-		i = 2
-	}
-	return duration(i)
-}
-
-func (d duration) String() string {
-	switch d {
-	case 0:
-		return "Sehr kurz (30-60 Minuten)"
-	case 1:
-		return "Kurz (2-4 Stunden)"
-	case 2:
-		return "Lang (4-8 Stunden)"
-	default:
-		return "Unbekannt"
+// List returns the built-in scenarios of a language followed by the ones
+// loaded from disk in that language or without a language.
+func List(language string) []Scenario {
+	fromDirMut.RLock()
+	defer fromDirMut.RUnlock()
+
+	list := slices.Clone(builtinOf(language))
+	loaded := make([]Scenario, 0, len(fromDir))
+	for _, s := range fromDir {
+		if s.Language == "" || s.Language == language {
+			loaded = append(loaded, s)
+		}
+	}
+	slices.SortFunc(loaded, func(a, b Scenario) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return append(list, loaded...)
+}
+
+// Get returns the built-in scenario with the given id in the given language
+// or the loaded scenario with the given id.
+func Get(id string, language string) (Scenario, error) {
+	for _, s := range builtinOf(language) {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+
+	fromDirMut.RLock()
+	defer fromDirMut.RUnlock()
+
+	if s, ok := fromDir[id]; ok {
+		return s, nil
+	}
+	return Scenario{}, fmt.Errorf("%w: %q", ErrNotFound, id)
+}
+
+// Parse reads a scenario file. The YAML front matter is optional, without it
+// the whole file is the body and the first line is the title.
+func Parse(id string, data []byte) (Scenario, error) {
+	s := Scenario{}
+	body := data
+	if rest, ok := bytes.CutPrefix(data, frontMatterDelimiter); ok {
+		frontMatter, after, found := bytes.Cut(rest, append([]byte("\n"), frontMatterDelimiter...))
+		if !found {
+			return Scenario{}, fmt.Errorf("%w: front matter is not closed", ErrInvalid)
+		}
+		if err := yaml.Unmarshal(frontMatter, &s); err != nil {
+			return Scenario{}, errors.Join(ErrInvalid, err)
+		}
+		body = after
+	}
+	s.ID = id
+	s.Body = strings.TrimSpace(string(body))
+	if s.Title == "" {
+		s.Title, _, _ = strings.Cut(s.Body, "\n")
+	}
+	return s, nil
+}
+
+// LoadDir loads every <id>.md and <id>.json file in dir as a scenario.
+// A missing dir is not an error, there are just no extra scenarios.
+func LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	loaded := make(map[string]Scenario)
+	var errs []error
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".md" && ext != ".json") {
+			continue
+		}
+		s, err := loadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load scenario %s: %w", entry.Name(), err))
+			continue
+		}
+		loaded[s.ID] = s
+	}
+
+	fromDirMut.Lock()
+	fromDir = loaded
+	fromDirMut.Unlock()
+	slog.Info("loaded scenarios", "dir", dir, "count", len(loaded))
+	return errors.Join(errs...)
+}
+
+func loadFile(filename string) (Scenario, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return Scenario{}, err
+	}
+
+	ext := filepath.Ext(filename)
+	id := strings.TrimSuffix(filepath.Base(filename), ext)
+	s := Scenario{}
+	if ext == ".json" {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return Scenario{}, err
+		}
+		s.ID = id
+	} else if s, err = Parse(id, b); err != nil {
+		return Scenario{}, err
+	}
+	s.Builtin = false
+	if isBuiltinID(s.ID) {
+		return Scenario{}, fmt.Errorf("%w: id %q is used by a built-in scenario", ErrInvalid, s.ID)
+	}
+	if err := s.Validate(); err != nil {
+		return Scenario{}, err
+	}
+	return s, nil
+}
+
+// Summary returns the scenario without its body, for the lobby.
+func (s Scenario) Summary() Scenario {
+	s.Body = ""
+	return s
+}
+
+// Validate checks a scenario written by a player.
+// Built-in scenarios are always valid.
+func (s *Scenario) Validate() error {
+	if s.Builtin {
+		return nil
+	}
+
+	var errs []error
+	if strings.TrimSpace(s.Title) == "" {
+		errs = append(errs, errors.New("title is empty"))
+	} else if len(s.Title) > maxTitleLength {
+		errs = append(errs, fmt.Errorf("title is longer than %d bytes", maxTitleLength))
+	}
+	if strings.TrimSpace(s.Description) == "" && strings.TrimSpace(s.Body) == "" {
+		errs = append(errs, errors.New("description is empty"))
+	} else if len(s.Description) > maxDescriptionLength {
+		errs = append(errs, fmt.Errorf("description is longer than %d bytes", maxDescriptionLength))
+	}
+	if len(s.Body) > maxBodyLength {
+		errs = append(errs, fmt.Errorf("body is longer than %d bytes", maxBodyLength))
+	}
+	for name, v := range map[string]string{"tone": s.Tone, "image": s.Image, "language": s.Language, "dice": s.Dice, "starting_location": s.StartingLocation} {
+		if len(v) > maxListItemLength {
+			errs = append(errs, fmt.Errorf("%s is longer than %d bytes", name, maxListItemLength))
+		}
+	}
+	errs = append(errs, validateList("themes", s.Themes))
+	errs = append(errs, validateList("npc_types", s.NPCTypes))
+	errs = append(errs, validateList("dangers", s.Dangers))
+	errs = append(errs, validateList("content_warnings", s.ContentWarnings))
+	errs = append(errs, validateEntities("npcs", s.NPCs))
+	errs = append(errs, validateEntities("factions", s.Factions))
+	if s.Language != "" {
+		if _, err := locale.Get(s.Language); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, d := range s.Durations {
+		if d > 2 {
+			errs = append(errs, fmt.Errorf("duration %d is not between 0 and 2", d))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return errors.Join(ErrInvalid, err)
+	}
+	return nil
+}
+
+func validateList(name string, list []string) error {
+	if len(list) > maxListLength {
+		return fmt.Errorf("%s has more than %d entries", name, maxListLength)
+	}
+	for _, item := range list {
+		if len(item) > maxListItemLength {
+			return fmt.Errorf("an entry of %s is longer than %d bytes", name, maxListItemLength)
+		}
+	}
+	return nil
+}
+
+func validateEntities(name string, entities []Entity) error {
+	if len(entities) > maxListLength {
+		return fmt.Errorf("%s has more than %d entries", name, maxListLength)
+	}
+	for _, e := range entities {
+		if strings.TrimSpace(e.Name) == "" {
+			return fmt.Errorf("an entry of %s has no name", name)
+		}
+		if len(e.Name) > maxListItemLength || len(e.Description) > maxDescriptionLength {
+			return fmt.Errorf("entry %q of %s is too long", e.Name, name)
+		}
+	}
+	return nil
+}
+
+// Text returns the scenario as it is given to the LLM, with the section
+// titles in the language of the campaign.
+func (s *Scenario) Text(l *locale.Locale) string {
+	sb := strings.Builder{}
+	if body := strings.TrimSpace(s.Body); body != "" {
+		sb.WriteString(body)
+		sb.WriteString("\n")
+	} else {
+		sb.WriteString(strings.TrimSpace(s.Title))
+		sb.WriteString("\n\n")
+		sb.WriteString(strings.TrimSpace(s.Description))
+		sb.WriteString("\n")
+	}
+	if tone := strings.TrimSpace(s.Tone); tone != "" {
+		writeSection(&sb, l.Scenario.Tone, []string{tone})
+	}
+	writeSection(&sb, l.Scenario.Themes, s.Themes)
+	writeSection(&sb, l.Scenario.NPCTypes, s.NPCTypes)
+	writeSection(&sb, l.Scenario.Dangers, s.Dangers)
+	return sb.String()
+}
+
+// EntityData returns the prebuilt NPCs and factions in the format of
+// ai.AI.EntityData.
+func (s *Scenario) EntityData(l *locale.Locale) map[string][]string {
+	data := make(map[string][]string, len(s.NPCs)+len(s.Factions))
+	for _, npc := range s.NPCs {
+		data[npc.Name] = []string{l.Scenario.NPC, l.Scenario.Description + npc.Description}
+	}
+	for _, faction := range s.Factions {
+		data[faction.Name] = []string{l.Scenario.Faction, l.Scenario.Description + faction.Description}
+	}
+	return data
+}
+
+// EventPlan returns the events the scenario fixes in advance.
+func (s *Scenario) EventPlan(l *locale.Locale) []string {
+	if s.StartingLocation == "" {
+		return nil
+	}
+	return []string{l.Scenario.StartingLocation + s.StartingLocation}
+}
+
+func writeSection(sb *strings.Builder, title string, items []string) {
+	written := false
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !written {
+			sb.WriteString("\n")
+			sb.WriteString(title)
+			sb.WriteString("\n")
+			written = true
+		}
+		sb.WriteString("- ")
+		sb.WriteString(item)
+		sb.WriteString("\n")
 	}
 }
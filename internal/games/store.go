@@ -0,0 +1,339 @@
+package games
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/server/hub"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+const (
+	// noticeTimeout is how long Shutdown waits for the notice to be sent.
+	noticeTimeout = 2 * time.Second
+	// cancelTimeout is how long Shutdown waits for the actions it cancelled.
+	cancelTimeout = 5 * time.Second
+)
+
+var (
+	lifecycleMut sync.Mutex
+	shuttingDown bool
+	inFlight     sync.WaitGroup
+	// running is cancelled by Shutdown when the running actions take too
+	// long, the LLM and TTS calls of turns and media stop with it.
+	running, cancelRunning = context.WithCancel(context.Background())
+)
+
+// begin registers a running action so Shutdown can wait for it.
+// Every successful call must be followed by a call to end.
+func begin() error {
+	lifecycleMut.Lock()
+	defer lifecycleMut.Unlock()
+
+	if shuttingDown {
+		return ErrShuttingDown
+	}
+	inFlight.Add(1)
+	return nil
+}
+
+func end() {
+	inFlight.Done()
+}
+
+// wait waits until no action is running or ctx expires.
+func wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting new actions, waits until running LLM and TTS calls
+// are done or ctx expires and cancels them then. Afterwards it tells the
+// connected clients, saves all games to dir and closes their AI clients.
+// A game whose turn still runs after the cancellation keeps its last save.
+func Shutdown(ctx context.Context, dir string) error {
+	lifecycleMut.Lock()
+	shuttingDown = true
+	lifecycleMut.Unlock()
+
+	if err := wait(ctx); err != nil {
+		slog.WarnContext(ctx, "timeout while waiting for running actions, cancelling them", "err", err)
+		cancelRunning()
+		cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelTimeout)
+		if err := wait(cancelCtx); err != nil {
+			slog.ErrorContext(ctx, "cancelled actions are still running", "err", err)
+		}
+		cancel()
+	}
+
+	// the turn locks are kept until the games are saved and closed
+	var idle []*Game
+	for _, g := range List() {
+		// a game that is still busy gets the notice in the default language
+		language := ""
+		if g.turn.TryLock() {
+			idle = append(idle, g)
+			g.mut.Lock()
+			g.stopMedia()
+			language = g.Settings.Language
+			g.mut.Unlock()
+		} else {
+			slog.ErrorContext(ctx, "game is still busy, keeping its last save", "game_id", g.ID)
+		}
+		hub.Broadcast(g.ID, WsNotice{"notice", locale.GetOrDefault(language).Notices.Shutdown})
+	}
+	// the hub is stopped right after this, so the notices have to be sent
+	// first, even if ctx already expired
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), noticeTimeout)
+	if err := hub.Flush(flushCtx); err != nil {
+		slog.WarnContext(ctx, "timeout while sending the shutdown notice", "err", err)
+	}
+	cancel()
+
+	var errs []error
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		errs = append(errs, err)
+	}
+	for _, g := range idle {
+		g.mut.Lock()
+		if err := g.write(dir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save game %s: %w", g.ID, err))
+		}
+		g.AI.Close()
+		g.mut.Unlock()
+		g.turn.Unlock()
+	}
+	ai.CloseTranscriber()
+
+	return errors.Join(errs...)
+}
+
+// Delete removes the game together with its saved state and save points in
+// dir and all files generated for it. Only the host may do that.
+func Delete(ctx context.Context, id string, playerID string, dir string) error {
+	if err := begin(); err != nil {
+		return err
+	}
+	defer end()
+
+	g, err := Get(id)
+	if err != nil {
+		return err
+	}
+	if !g.IsHost(playerID) {
+		return ErrNotHost
+	}
+	gamesMut.Lock()
+	// a concurrent Delete may have been first
+	ok := Games[id] == g
+	if ok {
+		delete(Games, id)
+	}
+	gamesMut.Unlock()
+	if !ok {
+		return ErrGameNotFound
+	}
+
+	// a running turn is finished first, its AI client is closed here
+	g.turn.Lock()
+	defer g.turn.Unlock()
+	g.mut.Lock()
+	g.stopMedia()
+	metrics.ActiveGames.WithLabelValues(g.State.String()).Dec()
+	metrics.DeleteGame(id)
+	g.AI.Close()
+	language := g.Settings.Language
+	g.mut.Unlock()
+
+	slog.InfoContext(ctx, "game deleted", "game_id", id)
+	hub.Broadcast(id, WsNotice{"notice", locale.GetOrDefault(language).Notices.Deleted})
+
+	var errs []error
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	if err := os.RemoveAll(savePointDir(dir, id)); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ai.DeleteGameAssets(id); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// save writes the game as <id>.json into dir after its running turn.
+func (g *Game) save(dir string) error {
+	g.turn.Lock()
+	defer g.turn.Unlock()
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return g.write(dir)
+}
+
+// write is save for callers that hold g.turn and g.mut.
+func (g *Game) write(dir string) error {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash can't leave a broken state behind
+	filename := filepath.Join(dir, g.ID+".json")
+	tmpFilename := filename + ".tmp"
+	if err := os.WriteFile(tmpFilename, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// LoadAll restores all games saved in dir.
+// A missing dir is not an error, there is just nothing to restore.
+func LoadAll(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if err := load(filepath.Join(dir, entry.Name())); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load %s: %w", entry.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Read reads the game saved in dir without restoring it, e.g. to export it
+// from the command line. Its AI is not connected.
+func Read(dir string, id string) (*Game, error) {
+	return read(filepath.Join(dir, id+".json"))
+}
+
+func read(filename string) (*Game, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Game{}
+	if err := json.Unmarshal(b, g); err != nil {
+		return nil, err
+	}
+	if g.ID == "" {
+		return nil, errors.New("game without id")
+	}
+	if g.Players == nil {
+		g.Players = make(map[string]*Player)
+	}
+	return g, nil
+}
+
+func load(filename string) error {
+	g, err := read(filename)
+	if err != nil {
+		return err
+	}
+	// the saved state replaces a game created in memory before, like the
+	// one of init
+	return restore(g, true)
+}
+
+// restore connects the AI of a game read from disk or an archive and adds
+// the game to the running games. A running game with the same id is
+// replaced if replace is set, otherwise restore fails with ErrGameExists.
+func restore(g *Game, replace bool) error {
+	if g.AI == nil {
+		g.AI = ai.Empty()
+	}
+	if g.State == GameStateRunning {
+		// games saved before assets were stored per game
+		if g.AI.GameID == "" {
+			g.AI.GameID = g.ID
+		}
+		if err := g.AI.Connect(context.Background(), cfg); err != nil {
+			return err
+		}
+		g.AI.DropMissingAssets()
+		// a turn that was interrupted by the shutdown can't be resumed
+		if g.Roll == nil {
+			g.AcceptingInput = true
+		}
+	}
+
+	gamesMut.Lock()
+	old, exists := Games[g.ID]
+	if !exists || replace {
+		Games[g.ID] = g
+	}
+	gamesMut.Unlock()
+	if exists && !replace {
+		g.AI.Close()
+		return fmt.Errorf("%w: %s", ErrGameExists, g.ID)
+	}
+	if exists {
+		metrics.ActiveGames.WithLabelValues(old.State.String()).Dec()
+	}
+	metrics.ActiveGames.WithLabelValues(g.State.String()).Inc()
+	return nil
+}
+
+// deleteAssets removes the assets stored for a game that could not be added,
+// e.g. by an import or a fork. Errors are only logged.
+func deleteAssets(id string) {
+	if err := ai.DeleteGameAssets(id); err != nil {
+		slog.Warn("failed to delete the assets of a game that was not added", "game_id", id, "err", err)
+	}
+}
+
+// importing are the ids reserved by imports that are not restored yet,
+// guarded by gamesMut.
+var importing = make(map[string]bool)
+
+// reserve reserves id for an import and reports whether it was free.
+func reserve(id string) bool {
+	gamesMut.Lock()
+	defer gamesMut.Unlock()
+
+	if _, ok := Games[id]; ok || importing[id] {
+		return false
+	}
+	importing[id] = true
+	return true
+}
+
+// release frees an id reserved with reserve, "" is ignored.
+func release(id string) {
+	if id == "" {
+		return
+	}
+	gamesMut.Lock()
+	delete(importing, id)
+	gamesMut.Unlock()
+}
@@ -0,0 +1,116 @@
+package games
+
+import (
+	"gameslabor/internal/ai"
+	"gameslabor/internal/server/hub"
+	"slices"
+)
+
+type (
+	// View is the part of a game that the players are allowed to see.
+	// The memory of the AI (event plan, histories, entity data) is left out.
+	View struct {
+		ID             string             `json:"id"`
+		State          GameState          `json:"state"`
+		Players        map[string]*Player `json:"players"`
+		Roll           *DiceRoll          `json:"roll"`
+		AcceptingInput bool               `json:"accepting_input"`
+		Settings       Settings           `json:"settings"`
+		ChatLength     int                `json:"chat_length"`
+		Host           string             `json:"host"`
+		SavePoints     []SavePointInfo    `json:"save_points"`
+		Lineage        *Lineage           `json:"lineage,omitempty"`
+	}
+
+	ChatPage struct {
+		Total    int              `json:"total"`
+		Offset   int              `json:"offset"`
+		Messages []ai.ChatMessage `json:"messages"`
+	}
+)
+
+func (g *Game) View() View {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	players := make(map[string]*Player, len(g.Players))
+	for id, p := range g.Players {
+		player := *p
+		players[id] = &player
+	}
+	return View{
+		ID:             g.ID,
+		State:          g.State,
+		Players:        players,
+		Roll:           g.Roll,
+		AcceptingInput: g.AcceptingInput,
+		Settings:       g.Settings,
+		ChatLength:     len(g.AI.ChatHistory),
+		Host:           g.Host,
+		SavePoints:     slices.Clone(g.SavePoints),
+		Lineage:        g.Lineage,
+	}
+}
+
+// Snapshot returns a copy of the game taken under its lock that shares
+// nothing the next turn changes, e.g. to export the campaign while it is
+// played. The AI of the copy is not connected.
+func (g *Game) Snapshot() *Game {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return g.snapshot()
+}
+
+// snapshot is Snapshot without the lock.
+// g.mut must be held.
+func (g *Game) snapshot() *Game {
+	players := make(map[string]*Player, len(g.Players))
+	for id, p := range g.Players {
+		player := *p
+		players[id] = &player
+	}
+	s := &Game{
+		ID:             g.ID,
+		Players:        players,
+		Roll:           g.Roll,
+		Dice:           g.Dice,
+		State:          g.State,
+		AcceptingInput: g.AcceptingInput,
+		Settings:       g.Settings,
+		Turn:           g.Turn,
+		Host:           g.Host,
+		SavePoints:     slices.Clone(g.SavePoints),
+		Lineage:        g.Lineage,
+	}
+	if g.AI != nil {
+		s.AI = g.AI.Copy()
+	}
+	return s
+}
+
+// SendState sends the full state of the game to a client registered for it.
+// The state is taken under the lock, so the client receives every change
+// after it and none before, see hub.Client.Send.
+func (g *Game) SendState(client *hub.Client) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	client.Send(WsFullOverwrite{Method: "full_overwrite", Value: g.snapshot()})
+}
+
+// Chat returns up to limit chat messages starting at offset. The messages
+// are copies, the media workers keep writing to the history.
+func (g *Game) Chat(offset, limit int) ChatPage {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	history := g.AI.ChatHistory
+	offset = clamp(0, offset, len(history))
+	end := clamp(offset, offset+limit, len(history))
+	return ChatPage{
+		Total:    len(history),
+		Offset:   offset,
+		Messages: slices.Clone(history[offset:end]),
+	}
+}
@@ -1,19 +1,48 @@
 package karmicdice
 
 import (
+	"errors"
 	"fmt"
+	"gameslabor/internal/metrics"
+	"log/slog"
 	"math"
 	"math/rand/v2"
 )
 
-// weight stores the karmic balance. This variable persists across calls to karmicDice.
-// A positive value increases the chance of success on the next roll.
-// A negative value decreases it.
-var weight float64 = 0.0
+// maxWeight bounds the weight of dice read from outside the server, e.g. from
+// an archive. Beyond ±20 every roll is decided anyway.
+const maxWeight = 100
 
-// Int performs a d20 roll, adjusted by a persistent "karmic" weight.
-// The outcome of the roll then modifies the weight for future rolls.
+var ErrInvalidWeight = errors.New("invalid dice weight")
+
+// Dice are karmic dice, every game rolls its own.
+type Dice struct {
+	// Weight stores the karmic balance. It persists across calls to Int.
+	// A positive value increases the chance of success on the next roll.
+	// A negative value decreases it.
+	Weight float64 `json:"weight"`
+}
+
+// Validate checks dice read from outside the server, e.g. from an archive.
+func (d Dice) Validate() error {
+	if math.IsNaN(d.Weight) || math.Abs(d.Weight) > maxWeight {
+		return fmt.Errorf("%w: %g is not between -%d and %d", ErrInvalidWeight, d.Weight, maxWeight, maxWeight)
+	}
+	return nil
+}
+
+// dice are the dice of Int.
+var dice Dice
+
+// Int performs a d20 roll with dice shared by all callers, see Dice.Int.
 func Int(difficulty int) int {
+	return dice.Int(difficulty)
+}
+
+// Int performs a d20 roll, adjusted by the persistent "karmic" weight.
+// The outcome of the roll then modifies the weight for future rolls.
+// Int is not safe for concurrent use.
+func (d *Dice) Int(difficulty int) int {
 	// A scaling factor for how much the weight changes.
 	// A smaller value means the karma adjusts more slowly.
 	const karmicFactor = 0.2
@@ -24,7 +53,7 @@ func Int(difficulty int) int {
 
 	// 2. Calculate the final roll by applying the current karmic weight.
 	// We round the result to get a whole number.
-	adjustedRoll := int(math.Round(float64(baseRoll) + weight))
+	adjustedRoll := int(math.Round(float64(baseRoll) + d.Weight))
 
 	// 3. Compare the un-adjusted baseRoll to the difficulty to update the weight.
 	// This feels more "pure": the weight affects the outcome, not the luck itself.
@@ -35,14 +64,14 @@ func Int(difficulty int) int {
 		// Formula: weight += |baseRoll - difficulty| * karmicFactor
 		difference := float64(difficulty - baseRoll)
 		weightChange := difference * karmicFactor
-		weight += weightChange
+		d.Weight += weightChange
 
-		fmt.Printf(
-			"Roll: %2d (Fail) -> Adjusted: %2d. Weight changes by +%.2f to %.2f\n",
-			baseRoll,
-			adjustedRoll,
-			weightChange,
-			weight,
+		slog.Debug(
+			"karmic roll failed",
+			"base_roll", baseRoll,
+			"adjusted_roll", adjustedRoll,
+			"weight_change", weightChange,
+			"weight", d.Weight,
 		)
 	} else {
 		// SUCCESS: The roll met or beat the difficulty.
@@ -51,17 +80,21 @@ func Int(difficulty int) int {
 		// Formula: weight -= (baseRoll - difficulty) * karmicFactor
 		difference := float64(baseRoll - difficulty)
 		weightChange := difference * karmicFactor
-		weight -= weightChange
+		d.Weight -= weightChange
 
-		fmt.Printf(
-			"Roll: %2d (Pass) -> Adjusted: %2d. Weight changes by -%.2f to %.2f\n",
-			baseRoll,
-			adjustedRoll,
-			weightChange,
-			weight,
+		slog.Debug(
+			"karmic roll passed",
+			"base_roll", baseRoll,
+			"adjusted_roll", adjustedRoll,
+			"weight_change", -weightChange,
+			"weight", d.Weight,
 		)
 	}
 
+	metrics.DiceRolls.WithLabelValues("base").Observe(float64(baseRoll))
+	metrics.DiceRolls.WithLabelValues("adjusted").Observe(float64(adjustedRoll))
+	metrics.DiceWeight.Set(d.Weight)
+
 	// 4. Return the final, karmically-adjusted roll value.
 	return adjustedRoll
 }
@@ -0,0 +1,201 @@
+// Package locale holds the texts of every campaign language. Each language
+// is a folder named by its code with its texts in messages.yaml, the prompts
+// of the LLM are templates in package prompts. A new language is added by
+// copying a folder here and in prompts and translating the files.
+package locale
+
+import (
+	"cmp"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default is the language of games without a language setting.
+const Default = "de"
+
+//go:embed */*.yaml
+var files embed.FS
+
+type (
+	Locale struct {
+		Code string `json:"code" yaml:"-"`
+		Name string `json:"name" yaml:"name"`
+		// TTSLanguage is the BCP-47 code of the TTS voices, e.g. de-DE.
+		TTSLanguage    string            `json:"-" yaml:"tts_language"`
+		ViolenceLevels []string          `json:"-" yaml:"violence_levels"`
+		Durations      []string          `json:"-" yaml:"durations"`
+		PlayerFields   PlayerFields      `json:"-" yaml:"player_fields"`
+		Transcribe     string            `json:"-" yaml:"transcribe"`
+		Scenario       ScenarioTexts     `json:"-" yaml:"scenario"`
+		Export         ExportTexts       `json:"-" yaml:"export"`
+		Eval           EvalTexts         `json:"-" yaml:"eval"`
+		Safety         SafetyTexts       `json:"-" yaml:"safety"`
+		Input          InputTexts        `json:"-" yaml:"input"`
+		Notices        NoticeTexts       `json:"-" yaml:"notices"`
+		Schema         map[string]string `json:"-" yaml:"schema"`
+	}
+
+	// PlayerFields are the names of the character description fields.
+	PlayerFields struct {
+		Name       string `yaml:"name"`
+		Age        string `yaml:"age"`
+		Appearance string `yaml:"appearance"`
+		Origin     string `yaml:"origin"`
+	}
+
+	// ScenarioTexts are used to write the fields of a scenario into the
+	// prompt and the memory of the AI.
+	ScenarioTexts struct {
+		Tone             string `yaml:"tone"`
+		Themes           string `yaml:"themes"`
+		NPCTypes         string `yaml:"npc_types"`
+		Dangers          string `yaml:"dangers"`
+		NPC              string `yaml:"npc"`
+		Faction          string `yaml:"faction"`
+		Description      string `yaml:"description"`
+		StartingLocation string `yaml:"starting_location"`
+	}
+
+	// ExportTexts are the headings and labels of exported campaigns.
+	ExportTexts struct {
+		Narrator string `yaml:"narrator"`
+		Player   string `yaml:"player"`
+		// Roll gets the result and the difficulty.
+		Roll        string `yaml:"roll"`
+		RollSuccess string `yaml:"roll_success"`
+		RollFailure string `yaml:"roll_failure"`
+		Audio       string `yaml:"audio"`
+		Characters  string `yaml:"characters"`
+		Story       string `yaml:"story"`
+		Appendix    string `yaml:"appendix"`
+		Events      string `yaml:"events"`
+		Entities    string `yaml:"entities"`
+	}
+
+	// EvalTexts are the words the validators of cmd/eval look for.
+	EvalTexts struct {
+		// DiceWords announce a roll, one of them has to be in the last
+		// sentences of a response that asks for a roll.
+		DiceWords []string `yaml:"dice_words"`
+		// FormalAddress are the words of a formal address, the players must
+		// not be addressed with them.
+		FormalAddress []string `yaml:"formal_address"`
+	}
+
+	SafetyTexts struct {
+		// Filtered replaces a narrator text the safety filter rejected.
+		Filtered string `yaml:"filtered"`
+	}
+
+	// NoticeTexts are shown to the connected players as an alert.
+	NoticeTexts struct {
+		Shutdown string `yaml:"shutdown"`
+		Deleted  string `yaml:"deleted"`
+	}
+
+	InputTexts struct {
+		// OutOfCharacter are phrases with which players try to talk to the
+		// LLM instead of the narrator, e.g. to change its instructions. They
+		// are looked for in the inputs of every language.
+		OutOfCharacter []string `yaml:"out_of_character"`
+		// TooLong gets the maximum length, RateLimited the inputs per
+		// minute. They tell the player why the input was rejected.
+		TooLong     string `yaml:"too_long"`
+		RateLimited string `yaml:"rate_limited"`
+	}
+)
+
+var ErrUnknown = errors.New("unknown language")
+
+var locales = make(map[string]*Locale)
+
+func init() {
+	dirs, err := fs.ReadDir(files, ".")
+	if err != nil {
+		panic(err)
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		l, err := load(dir.Name())
+		if err != nil {
+			panic(fmt.Errorf("locale %s: %w", dir.Name(), err))
+		}
+		locales[l.Code] = l
+	}
+	if _, ok := locales[Default]; !ok {
+		panic("default locale " + Default + " is missing")
+	}
+}
+
+func load(code string) (*Locale, error) {
+	l := &Locale{Code: code}
+	messages, err := files.ReadFile(code + "/messages.yaml")
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(messages, l); err != nil {
+		return nil, err
+	}
+	if len(l.ViolenceLevels) != 4 || len(l.Durations) != 3 {
+		return nil, errors.New("needs 4 violence levels and 3 durations")
+	}
+	if l.Safety.Filtered == "" {
+		return nil, errors.New("safety.filtered is empty")
+	}
+	if l.Input.TooLong == "" || l.Input.RateLimited == "" {
+		return nil, errors.New("input.too_long or input.rate_limited is empty")
+	}
+	if l.Notices.Shutdown == "" || l.Notices.Deleted == "" {
+		return nil, errors.New("notices.shutdown or notices.deleted is empty")
+	}
+	return l, nil
+}
+
+// Get returns the locale with the given code, an empty code is the default.
+func Get(code string) (*Locale, error) {
+	if code == "" {
+		code = Default
+	}
+	if l, ok := locales[code]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknown, code)
+}
+
+// GetOrDefault is Get for codes that were validated before. Unknown codes
+// fall back to the default language.
+func GetOrDefault(code string) *Locale {
+	if l, err := Get(code); err == nil {
+		return l
+	}
+	return locales[Default]
+}
+
+// List returns all languages sorted by code.
+func List() []*Locale {
+	list := make([]*Locale, 0, len(locales))
+	for _, l := range locales {
+		list = append(list, l)
+	}
+	slices.SortFunc(list, func(a, b *Locale) int {
+		return cmp.Compare(a.Code, b.Code)
+	})
+	return list
+}
+
+// ViolenceLevel describes the violence level i, which is clamped to 0-3.
+func (l *Locale) ViolenceLevel(i uint8) string {
+	return l.ViolenceLevels[min(i, uint8(len(l.ViolenceLevels)-1))]
+}
+
+// Duration describes the duration i, which is clamped to 0-2.
+func (l *Locale) Duration(i uint8) string {
+	return l.Durations[min(i, uint8(len(l.Durations)-1))]
+}
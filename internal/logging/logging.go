@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type attrsKey struct{}
+
+// Setup installs the default slog logger.
+// level is one of debug, info, warn, error and format is text or json.
+func Setup(level string, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	var h slog.Handler
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "text", "":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+// With returns a copy of ctx that carries additional log attributes.
+// Every record logged with this context (e.g. slog.InfoContext) gets them.
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := attrsFrom(ctx)
+	newAttrs := make([]slog.Attr, 0, len(attrs)+len(args)/2)
+	newAttrs = append(newAttrs, attrs...)
+	r := slog.Record{}
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		newAttrs = append(newAttrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, newAttrs)
+}
+
+// NewTurn detaches ctx from the cancellation of the request it came from, so
+// a turn finishes even if the player disconnects, and tags it with a new
+// correlation id.
+func NewTurn(ctx context.Context, action string) context.Context {
+	return With(context.WithoutCancel(ctx), "action", action, "correlation_id", uuid.NewString())
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(attrsFrom(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gameslabor"
+
+var (
+	ActiveGames = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "games",
+		Help:      "Number of games by state.",
+	}, []string{"state"})
+
+	Connections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connections",
+		Help:      "Connected clients per game and transport.",
+	}, []string{"game_id", "transport"})
+
+	Actions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_total",
+		Help:      "Game actions sent by clients by action and outcome.",
+	}, []string{"action", "outcome"})
+
+	ActionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "Time until a game action is done, including LLM calls.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"action"})
+
+	InputsFlagged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "player_inputs_flagged_total",
+		Help:      "Player inputs rejected (too_long, rate_limited) or answered in character (out_of_character) by reason.",
+	}, []string{"reason"})
+
+	LLMDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of LLM requests by model.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"model"})
+
+	LLMErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "errors_total",
+		Help:      "Failed LLM requests by model.",
+	}, []string{"model"})
+
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "tokens_total",
+		Help:      "Tokens used by model and type (prompt, response, thoughts).",
+	}, []string{"model", "type"})
+
+	SafetyFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "safety_filtered_total",
+		Help:      "Narrator texts rejected by the safety filter by filter (keyword, classifier) and outcome (retried, replaced).",
+	}, []string{"filter", "outcome"})
+
+	TTSDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "tts",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of text-to-speech requests by voice.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30},
+	}, []string{"voice"})
+
+	TTSErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tts",
+		Name:      "errors_total",
+		Help:      "Failed text-to-speech requests by voice.",
+	}, []string{"voice"})
+
+	TTSBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tts",
+		Name:      "audio_bytes_total",
+		Help:      "Bytes of synthesized audio by voice.",
+	}, []string{"voice"})
+
+	ImageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "image",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of scene illustrations by backend.",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"backend"})
+
+	ImageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "image",
+		Name:      "errors_total",
+		Help:      "Failed scene illustrations by backend.",
+	}, []string{"backend"})
+
+	STTDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "stt",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of speech-to-text requests by backend.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30},
+	}, []string{"backend"})
+
+	STTErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "stt",
+		Name:      "errors_total",
+		Help:      "Failed speech-to-text requests by backend.",
+	}, []string{"backend"})
+
+	TTSCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tts",
+		Name:      "cache_hits_total",
+		Help:      "Text-to-speech requests answered from the cache.",
+	})
+
+	TTSPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "tts",
+		Name:      "pending_messages",
+		Help:      "Chat messages waiting for or in text-to-speech across all games.",
+	})
+
+	DiceRolls = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "dice",
+		Name:      "rolls",
+		Help:      "Distribution of d20 rolls before (base) and after (adjusted) the karmic weight.",
+		Buckets:   prometheus.LinearBuckets(1, 1, 25),
+	}, []string{"kind"})
+
+	DiceWeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "dice",
+		Name:      "karmic_weight",
+		Help:      "Karmic weight added to the next roll of the game that rolled last.",
+	})
+
+	HubMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "hub",
+		Name:      "messages_total",
+		Help:      "Messages broadcasted through the hub.",
+	})
+
+	HubSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "hub",
+		Name:      "subscribers",
+		Help:      "Subscribers registered at the hub.",
+	})
+)
+
+// QueueDepth registers a gauge that reports the length of a queue on scrape.
+func QueueDepth(subsystem, queue string, length func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   subsystem,
+		Name:        "queue_depth",
+		Help:        "Items waiting in a queue.",
+		ConstLabels: prometheus.Labels{"queue": queue},
+	}, func() float64 { return float64(length()) })
+}
+
+// DeleteGame removes the series of a deleted game, so they don't pile up.
+// Clients that are still connected decrement their detached gauges.
+func DeleteGame(gameID string) {
+	Connections.DeletePartialMatch(prometheus.Labels{"game_id": gameID})
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,278 @@
+// Package prompts renders the prompts of the LLM from text/template files.
+//
+// Each campaign language is a folder named by its code with one <name>.tmpl
+// file per template, see Names. The embedded templates can be overridden by
+// the same files in a directory, e.g. <dir>/de/start.tmpl, so prompts can be
+// tuned without a rebuild. Every template gets its own data type.
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"gameslabor/internal/locale"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// The names of the templates.
+const (
+	// System is the system instruction of every request, it gets SystemData.
+	System = "system"
+	// Start starts the campaign and lets the LLM plan the story, it gets
+	// StartData.
+	Start = "start"
+	// PlayerInput continues the story after the input of a player, it gets
+	// PlayerInputData.
+	PlayerInput = "player_input"
+	// OutOfCharacter continues the story after an input that tried to talk
+	// to the LLM instead of the narrator, it gets PlayerInputData.
+	OutOfCharacter = "out_of_character"
+	// RollSuccess and RollFailure continue the story after a roll, they get
+	// RollData.
+	RollSuccess = "roll_success"
+	RollFailure = "roll_failure"
+	// Compaction is the memory of the AI condensed into the game data that
+	// is sent with every request, it gets CompactionData.
+	Compaction = "compaction"
+	// XCard rewrites the last message of the narrator after a player played
+	// the X-card, it gets XCardData.
+	XCard = "x_card"
+	// SafetyCheck asks the classifier whether a narrator text crosses the
+	// boundaries of the table, it gets SafetyCheckData.
+	SafetyCheck = "safety_check"
+	// SafetyRewrite asks for a response again after the filter rejected it,
+	// it gets SafetyRewriteData.
+	SafetyRewrite = "safety_rewrite"
+)
+
+// Names are all templates, every language has a file for each one.
+var Names = []string{System, Start, PlayerInput, OutOfCharacter, RollSuccess, RollFailure, Compaction, XCard, SafetyCheck, SafetyRewrite}
+
+//go:embed */*.tmpl
+var files embed.FS
+
+var ErrUnknown = errors.New("unknown prompt template")
+
+type (
+	SystemData struct {
+		// Language is the name of the campaign language, e.g. Deutsch.
+		Language string
+		Boundaries
+	}
+
+	// Boundaries are the limits the table agreed on.
+	Boundaries struct {
+		// Lines are topics that must never appear.
+		Lines []string
+		// Veils are topics that may happen, but only off-screen.
+		Veils []string
+		// XCarded are excerpts of messages the players rejected with the
+		// X-card without naming a topic.
+		XCarded []string
+	}
+
+	// Player is a player character as described in the lobby.
+	Player struct {
+		ID         string
+		Name       string
+		Age        string
+		Origin     string
+		Appearance string
+	}
+
+	StartData struct {
+		// Scenario is the text of the scenario with its entities.
+		Scenario          string
+		ViolenceLevel     uint8
+		ViolenceLevelText string
+		Duration          uint8
+		DurationText      string
+		Players           []Player
+	}
+
+	PlayerInputData struct {
+		Player Player
+		Input  string
+	}
+
+	RollData struct {
+		Result     uint8
+		Difficulty uint8
+	}
+
+	CompactionData struct {
+		// Data is the plan, the histories, the entities and the recent chat
+		// messages as JSON.
+		Data string
+		// OmittedMessages is the number of older chat messages that are only
+		// known from the histories.
+		OmittedMessages int
+	}
+
+	XCardData struct {
+		// Topic is what the player wants to avoid, it may be empty.
+		Topic string
+		// Message is the rejected message of the narrator.
+		Message string
+	}
+
+	SafetyCheckData struct {
+		Boundaries
+		Text string
+	}
+
+	SafetyRewriteData struct {
+		// Reason names the keyword or topic the response was rejected for.
+		Reason string
+	}
+)
+
+// zeroData are the data types of the templates, a template has to execute
+// with the zero value of its type to be loaded.
+var zeroData = map[string]any{
+	System:         SystemData{},
+	Start:          StartData{},
+	PlayerInput:    PlayerInputData{},
+	OutOfCharacter: PlayerInputData{},
+	RollSuccess:    RollData{},
+	RollFailure:    RollData{},
+	Compaction:     CompactionData{},
+	XCard:          XCardData{},
+	SafetyCheck:    SafetyCheckData{},
+	SafetyRewrite:  SafetyRewriteData{},
+}
+
+// Set are the templates of every language.
+type Set struct {
+	// templates are the template sets by language code.
+	templates map[string]*template.Template
+	// dir is the override dir, empty for the embedded templates.
+	dir string
+	// overridden is the number of files read from the override dir.
+	overridden int
+}
+
+var (
+	// current are the templates used by the games.
+	current    *Set
+	currentMut sync.RWMutex
+)
+
+func init() {
+	set, err := LoadSet("")
+	if err != nil {
+		panic(err)
+	}
+	current = set
+}
+
+// LoadDir replaces the current templates with the embedded ones overridden by
+// the files in dir. The templates are only replaced if all of them are valid.
+// A missing dir is not an error, the embedded templates are used then.
+func LoadDir(dir string) error {
+	set, err := LoadSet(dir)
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		slog.Info("loaded prompt templates", "dir", dir, "overridden", set.overridden)
+	}
+
+	currentMut.Lock()
+	current = set
+	currentMut.Unlock()
+	return nil
+}
+
+// Current returns the templates used by the games.
+func Current() *Set {
+	currentMut.RLock()
+	defer currentMut.RUnlock()
+	return current
+}
+
+// Render executes a template of the current templates, see Set.Render.
+func Render(language string, name string, data any) (string, error) {
+	return Current().Render(language, name, data)
+}
+
+// LoadSet loads the embedded templates overridden by the files in dir without
+// replacing the current templates, e.g. to compare two versions of a prompt.
+func LoadSet(dir string) (*Set, error) {
+	set := &Set{templates: make(map[string]*template.Template), dir: dir}
+	var errs []error
+	for _, l := range locale.List() {
+		t := template.New(l.Code).Option("missingkey=error")
+		for _, name := range Names {
+			filename := l.Code + "/" + name + ".tmpl"
+			text, err := files.ReadFile(filename)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filename, err))
+				continue
+			}
+			if dir != "" {
+				override, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(filename)))
+				if err == nil {
+					text = override
+					set.overridden++
+				} else if !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			if _, err := t.New(name).Parse(string(text)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filename, err))
+				continue
+			}
+		}
+		for _, name := range Names {
+			if t.Lookup(name) == nil {
+				continue
+			}
+			if err := t.ExecuteTemplate(io.Discard, name, zeroData[name]); err != nil {
+				errs = append(errs, fmt.Errorf("%s/%s.tmpl: %w", l.Code, name, err))
+			}
+		}
+		set.templates[l.Code] = t
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return set, nil
+}
+
+// Dir returns the directory that overrides the embedded templates of the set,
+// it is empty if no file of it is used.
+func (s *Set) Dir() string {
+	if s.overridden == 0 {
+		return ""
+	}
+	return s.dir
+}
+
+// Render executes the template name of the language with the given code,
+// an empty code is the default language. Leading and trailing whitespace is
+// removed, so files may end with a newline.
+func (s *Set) Render(language string, name string, data any) (string, error) {
+	l, err := locale.Get(language)
+	if err != nil {
+		return "", err
+	}
+
+	t := s.templates[l.Code]
+	if t == nil || t.Lookup(name) == nil {
+		return "", fmt.Errorf("%w %q", ErrUnknown, name)
+	}
+	b := bytes.Buffer{}
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,74 @@
+package prompts
+
+import (
+	"context"
+	"gameslabor/internal/locale"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay collects the events of one save, editors often write a file in
+// several steps.
+const reloadDelay = 200 * time.Millisecond
+
+// Watch reloads the templates whenever a file in dir changes until ctx is
+// done. It is meant for development, invalid templates are logged and the
+// previous ones are kept. dir and its language folders are created if they
+// are missing, so new overrides are noticed.
+func Watch(ctx context.Context, dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// fsnotify is not recursive, every language folder is watched itself
+	dirs := []string{dir}
+	for _, l := range locale.List() {
+		dirs = append(dirs, filepath.Join(dir, l.Code))
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			watcher.Close()
+			return err
+		}
+		if err := watcher.Add(d); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		reload := time.NewTimer(reloadDelay)
+		reload.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Ext(event.Name) == ".tmpl" {
+					reload.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("error watching prompt templates", "dir", dir, "err", err)
+			case <-reload.C:
+				if err := LoadDir(dir); err != nil {
+					slog.Error("invalid prompt templates, keeping the previous ones", "dir", dir, "err", err)
+				}
+			}
+		}
+	}()
+	slog.Info("watching prompt templates", "dir", dir)
+	return nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"gameslabor/internal/config"
 	"gameslabor/internal/games"
 	"net/http"
 	"strings"
@@ -16,6 +17,14 @@ var upgrader = websocket.Upgrader{
 
 type apiFunc = func(http.ResponseWriter, *http.Request)
 
+// cfg is the configuration of the server, see SetConfig.
+var cfg = config.Default()
+
+// SetConfig sets the configuration the handlers use, e.g. for the data dir.
+func SetConfig(c *config.Config) {
+	cfg = c
+}
+
 func newGame(w http.ResponseWriter, r *http.Request) {
 	game := games.New()
 	id := game.ID
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"gameslabor/internal/games"
+	"gameslabor/internal/metrics"
+	"gameslabor/internal/server/context"
+	"gameslabor/internal/server/hub"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// gameEvents_keepAlive is the interval of comment lines sent to keep proxies
+// from closing an idle stream.
+const gameEvents_keepAlive = 20 * time.Second
+
+func init() {
+	apiRegister["/game_events"] = gameEvents
+	apiRegister["/game_action"] = gameAction
+}
+
+// gameEvents streams the same messages as gameState via Server-Sent Events
+// for clients that can't open a websocket.
+func gameEvents(w http.ResponseWriter, r *http.Request) {
+	dataID := r.URL.Query().Get("id")
+
+	game, err := games.Get(dataID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.From(w, r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// disable response buffering in nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	slog.InfoContext(ctx, "event stream connected", "game_id", dataID, "user_id", ctx.UserID)
+	connections := metrics.Connections.WithLabelValues(dataID, "sse")
+	connections.Inc()
+	defer connections.Dec()
+
+	sub := hub.NewSSE()
+	// the full state is the first event after the registration, so no
+	// change gets lost in between
+	hubClient := hub.Register(dataID, sub)
+	defer hubClient.Close()
+	game.SendState(hubClient)
+
+	keepAlive := time.NewTicker(gameEvents_keepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := gameEvents_write(w, data); err != nil {
+				slog.InfoContext(ctx, "event stream closed", "game_id", dataID, "user_id", ctx.UserID, "err", err)
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func gameEvents_write(w io.Writer, data []byte) error {
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+// gameAction accepts the actions that are otherwise sent over the websocket.
+func gameAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rest_methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	game, ok := rest_lookup(w, r)
+	if !ok {
+		return
+	}
+	ctx := context.From(w, r)
+	message, err := io.ReadAll(io.LimitReader(r.Body, gameState_readLimit()))
+	if err != nil {
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// the response is sent before the action runs, so rejected input is not
+	// reported to SSE clients
+	if err := gameState_handleAction(ctx, game, message, nil); err != nil {
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -2,15 +2,18 @@ package api
 
 import (
 	"bytes"
+	gocontext "context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gameslabor/internal/ai"
 	"gameslabor/internal/games"
+	"gameslabor/internal/metrics"
 	"gameslabor/internal/server/context"
 	"gameslabor/internal/server/hub"
-	"log"
+	"log/slog"
 	"net/http"
-
-	"github.com/gorilla/websocket"
+	"time"
 )
 
 type (
@@ -23,92 +26,178 @@ type (
 	}
 
 	gameState_startAction struct {
-		Scenario      string `json:"scenario"`
-		ViolenceLevel uint8  `json:"violence_level"`
-		Duration      uint8  `json:"duration"`
+		games.Settings
 	}
 
 	gameState_userInput struct {
 		Input string `json:"input"`
 	}
+
+	gameState_setBoundaries struct {
+		Boundaries ai.Boundaries `json:"boundaries"`
+	}
+
+	gameState_xCard struct {
+		Topic string `json:"topic"`
+	}
 )
 
 func gameState(w http.ResponseWriter, r *http.Request) {
 	ctx := context.From(w, r)
 	dataID := r.URL.Query().Get("id")
 
-	game, gameFound := games.Games[dataID]
-	if !gameFound {
+	game, err := games.Get(dataID)
+	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	fmt.Println("game state", dataID)
-
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		fmt.Printf("websocket upgrade error: %v\n", err)
+		slog.WarnContext(ctx, "websocket upgrade error", "game_id", dataID, "user_id", ctx.UserID, "err", err)
 		return
 	}
 	defer c.Close()
 
-	fmt.Println("ws connected")
+	slog.InfoContext(ctx, "websocket connected", "game_id", dataID, "user_id", ctx.UserID)
+	connections := metrics.Connections.WithLabelValues(dataID, "websocket")
+	connections.Inc()
+	defer connections.Dec()
 
-	if err := gameState_sendFullState(c, game); err != nil {
-		log.Printf("error sending full state: %v", err)
-		return
-	}
-
-	hubClient := hub.Register(dataID, c)
+	c.SetReadLimit(gameState_readLimit())
+	sub := hub.NewWebSocket(c)
+	// the full state follows the registration, so no change gets lost in
+	// between
+	hubClient := hub.Register(dataID, sub)
 	defer hubClient.Close()
+	game.SendState(hubClient)
 
 	for {
 		_, message, err := c.ReadMessage()
 		if err != nil {
-			log.Println("ws read:", err)
+			slog.InfoContext(ctx, "websocket closed", "game_id", dataID, "user_id", ctx.UserID, "err", err)
 			break
 		}
 
-		action := gameState_action{}
-		{
-			jd := json.NewDecoder(bytes.NewReader(message))
-			if err := jd.Decode(&action); err != nil {
-				log.Println("ws read:", err)
-				break
-			}
+		if err := gameState_handleAction(ctx, game, message, sub); err != nil {
+			slog.WarnContext(ctx, "invalid websocket action", "game_id", dataID, "user_id", ctx.UserID, "err", err)
+		}
+	}
+}
+
+// gameState_maxActionSize is the size of the largest action apart from the
+// input, a start action with a custom scenario.
+const gameState_maxActionSize = 128 << 10
+
+// gameState_readLimit limits the size of an action sent by a client, an input
+// has at most 4 bytes per character.
+func gameState_readLimit() int64 {
+	return int64(cfg.Input.MaxLength)*4 + gameState_maxActionSize
+}
+
+// gameState_handleAction decodes an action sent by a client and runs it in the
+// background. It is shared by the websocket and the SSE transport. sub is the
+// client that sent the action, it is told if its input was rejected. It may
+// be nil.
+func gameState_handleAction(ctx *context.Context, game *games.Game, message []byte, sub hub.Subscriber) error {
+	action := gameState_action{}
+	{
+		jd := json.NewDecoder(bytes.NewReader(message))
+		if err := jd.Decode(&action); err != nil {
+			return err
 		}
+	}
 
-		switch action.Action {
-		case "set_player_character_description":
-			descriptionAction := gameState_setPlayerCharacterDescription{}
-			jd := json.NewDecoder(bytes.NewReader(message))
-			if err := jd.Decode(&descriptionAction); err != nil {
-				log.Println("ws read:", err)
-				break
-			}
-			go game.SetPlayerDescription(games.Player{ID: ctx.UserID, Description: descriptionAction.Player})
-		case "start":
-			startAction := gameState_startAction{}
-			jd := json.NewDecoder(bytes.NewReader(message))
-			if err := jd.Decode(&startAction); err != nil {
-				log.Println("ws read:", err)
-				break
-			}
-			go game.Start(startAction.Scenario, startAction.ViolenceLevel, startAction.Duration)
-		case "user_input":
-			inputAction := gameState_userInput{}
-			jd := json.NewDecoder(bytes.NewReader(message))
-			if err := jd.Decode(&inputAction); err != nil {
-				log.Println("ws read:", err)
-				break
-			}
-			go game.PlayerInput(ctx.UserID, inputAction.Input)
-		case "continue_after_roll":
-			go game.ContinueAfterRoll()
+	switch action.Action {
+	case "set_player_character_description":
+		descriptionAction := gameState_setPlayerCharacterDescription{}
+		jd := json.NewDecoder(bytes.NewReader(message))
+		if err := jd.Decode(&descriptionAction); err != nil {
+			return err
+		}
+		gameState_run(ctx, action.Action, func(actionCtx gocontext.Context) error {
+			return game.SetPlayerDescription(actionCtx, games.Player{ID: ctx.UserID, Description: descriptionAction.Player})
+		})
+	case "start":
+		startAction := gameState_startAction{}
+		jd := json.NewDecoder(bytes.NewReader(message))
+		if err := jd.Decode(&startAction); err != nil {
+			return err
 		}
+		gameState_run(ctx, action.Action, func(actionCtx gocontext.Context) error {
+			return game.Start(actionCtx, startAction.Settings)
+		})
+	case "user_input":
+		inputAction := gameState_userInput{}
+		jd := json.NewDecoder(bytes.NewReader(message))
+		if err := jd.Decode(&inputAction); err != nil {
+			return err
+		}
+		gameState_run(ctx, action.Action, func(actionCtx gocontext.Context) error {
+			err := game.PlayerInput(actionCtx, ctx.UserID, inputAction.Input)
+			gameState_noticeInputError(sub, game, err)
+			return err
+		})
+	case "continue_after_roll":
+		gameState_run(ctx, action.Action, game.ContinueAfterRoll)
+	case "set_boundaries":
+		boundariesAction := gameState_setBoundaries{}
+		jd := json.NewDecoder(bytes.NewReader(message))
+		if err := jd.Decode(&boundariesAction); err != nil {
+			return err
+		}
+		gameState_run(ctx, action.Action, func(actionCtx gocontext.Context) error {
+			return game.SetBoundaries(actionCtx, ctx.UserID, boundariesAction.Boundaries)
+		})
+	case "x_card":
+		xCardAction := gameState_xCard{}
+		jd := json.NewDecoder(bytes.NewReader(message))
+		if err := jd.Decode(&xCardAction); err != nil {
+			return err
+		}
+		gameState_run(ctx, action.Action, func(actionCtx gocontext.Context) error {
+			err := game.XCard(actionCtx, ctx.UserID, xCardAction.Topic)
+			gameState_noticeInputError(sub, game, err)
+			return err
+		})
+	default:
+		metrics.Actions.WithLabelValues("unknown", "invalid").Inc()
+		return fmt.Errorf("unknown action %q", action.Action)
 	}
+	return nil
 }
 
-func gameState_sendFullState(c *websocket.Conn, game *games.Game) error {
-	return c.WriteJSON(games.WsFullOverwrite{Method: "full_overwrite", Value: game})
+// gameState_run runs an action in the background and records its outcome.
+func gameState_run(ctx *context.Context, action string, fn func(gocontext.Context) error) {
+	actionCtx := ctx.Action(action)
+	go func() {
+		start := time.Now()
+		err := fn(actionCtx)
+		metrics.ActionDuration.WithLabelValues(action).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.Actions.WithLabelValues(action, "error").Inc()
+			slog.WarnContext(actionCtx, "action failed", "err", err)
+			return
+		}
+		metrics.Actions.WithLabelValues(action, "ok").Inc()
+	}()
+}
+
+// gameState_noticeInputError tells the player why their input was rejected,
+// other errors of an action are only logged.
+func gameState_noticeInputError(sub hub.Subscriber, game *games.Game, err error) {
+	var message string
+	switch {
+	case sub == nil:
+		return
+	case errors.Is(err, games.ErrInputTooLong):
+		message = fmt.Sprintf(game.Locale().Input.TooLong, cfg.Input.MaxLength)
+	case errors.Is(err, games.ErrRateLimited):
+		message = fmt.Sprintf(game.Locale().Input.RateLimited, cfg.Input.PerMinute)
+	default:
+		return
+	}
+	if err := sub.Send(games.WsNotice{Method: "notice", Message: message}); err != nil {
+		slog.Warn("error sending notice", "err", err)
+	}
 }
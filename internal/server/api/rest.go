@@ -0,0 +1,606 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gameslabor/internal/ai"
+	"gameslabor/internal/games"
+	"gameslabor/internal/games/export"
+	"gameslabor/internal/games/scenarios"
+	"gameslabor/internal/locale"
+	"gameslabor/internal/server/context"
+	"gameslabor/internal/server/public"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+type (
+	rest_error struct {
+		Error string `json:"error"`
+	}
+
+	rest_gameList struct {
+		Games []games.View `json:"games"`
+	}
+
+	rest_scenarioList struct {
+		Scenarios []scenarios.Scenario `json:"scenarios"`
+	}
+
+	rest_languageList struct {
+		Languages []*locale.Locale `json:"languages"`
+	}
+
+	rest_modelList struct {
+		Models []ai.Model `json:"models"`
+	}
+
+	rest_inputRequest struct {
+		Input string `json:"input"`
+	}
+
+	rest_transcript struct {
+		Transcript string `json:"transcript"`
+	}
+
+	rest_savePointRequest struct {
+		Name string `json:"name"`
+	}
+
+	rest_xCardRequest struct {
+		Topic string `json:"topic"`
+	}
+)
+
+const (
+	rest_defaultChatLimit = 50
+	rest_maxChatLimit     = 200
+	// rest_maxRecordingSize is about 5 minutes of opus encoded speech
+	rest_maxRecordingSize = 10 << 20
+	// rest_maxArchiveSize leaves room for a game that uses its whole asset quota
+	rest_maxArchiveSize = 1 << 30
+	// rest_jsonOverhead is the room for the JSON around an input
+	rest_jsonOverhead = 1 << 10
+	// rest_maxBodySize limits the small JSON bodies, e.g. the boundaries of
+	// up to 20 lines and 20 veils of 200 characters
+	rest_maxBodySize = 64 << 10
+)
+
+func init() {
+	apiRegister["/games"] = rest_games
+	apiRegister["/scenarios"] = rest_scenarios
+	apiRegister["/languages"] = rest_languages
+	apiRegister["/models"] = rest_models
+	apiRegister["/game"] = rest_game
+	apiRegister["/game/join"] = rest_join
+	apiRegister["/game/character"] = rest_character
+	apiRegister["/game/start"] = rest_start
+	apiRegister["/game/input"] = rest_input
+	apiRegister["/game/continue"] = rest_continue
+	apiRegister["/game/boundaries"] = rest_boundaries
+	apiRegister["/game/xcard"] = rest_xCard
+	apiRegister["/game/chat"] = rest_chat
+	apiRegister["/game/transcribe"] = rest_transcribe
+	apiRegister["/game/export"] = rest_export
+	apiRegister["/game/archive"] = rest_archive
+	apiRegister["/games/import"] = rest_import
+	apiRegister["/game/savepoints"] = rest_savePoints
+	apiRegister["/game/fork"] = rest_fork
+	apiRegister["/game/tree"] = rest_tree
+}
+
+func rest_games(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := games.List()
+		resp := rest_gameList{Games: make([]games.View, 0, len(list))}
+		for _, g := range list {
+			resp.Games = append(resp.Games, g.View())
+		}
+		rest_writeJSON(w, http.StatusOK, resp)
+	case http.MethodPost:
+		ctx := context.From(w, r)
+		settings := games.Settings{}
+		if r.ContentLength != 0 {
+			if !rest_decode(w, r, gameState_maxActionSize, &settings) {
+				return
+			}
+		}
+		game, err := games.Create(ctx.Action("create_game"), ctx.UserID, settings)
+		if err != nil {
+			rest_writeGameError(w, err)
+			return
+		}
+		rest_writeJSON(w, http.StatusCreated, game.View())
+	default:
+		rest_methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func rest_scenarios(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	language := r.URL.Query().Get("language")
+	if _, err := locale.Get(language); err != nil {
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	list := scenarios.List(language)
+	for i := range list {
+		list[i] = list[i].Summary()
+		list[i].Image = public.URL(list[i].Image)
+	}
+	rest_writeJSON(w, http.StatusOK, rest_scenarioList{Scenarios: list})
+}
+
+func rest_languages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, rest_languageList{Languages: locale.List()})
+}
+
+func rest_models(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	models, err := ai.TextModels(r.Context(), cfg)
+	if err != nil {
+		rest_writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, rest_modelList{Models: models})
+}
+
+func rest_game(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		game, ok := rest_lookup(w, r)
+		if !ok {
+			return
+		}
+		rest_writeJSON(w, http.StatusOK, game.View())
+	case http.MethodDelete:
+		game, ok := rest_lookup(w, r)
+		if !ok {
+			return
+		}
+		ctx := context.From(w, r)
+		if err := games.Delete(ctx.Action("delete_game"), game.ID, ctx.UserID, cfg.DataDir); err != nil {
+			rest_writeGameError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		rest_methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func rest_join(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rest_methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	game, ok := rest_lookup(w, r)
+	if !ok {
+		return
+	}
+	ctx := context.From(w, r)
+	game.AddPlayer(ctx.UserID)
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+func rest_character(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	description := games.PlayerData{}
+	if !rest_decode(w, r, rest_maxBodySize, &description) {
+		return
+	}
+	if err := game.SetPlayerDescription(ctx.Action("set_player_character_description"), games.Player{ID: ctx.UserID, Description: description}); err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+func rest_start(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	settings := games.Settings{}
+	if r.ContentLength != 0 {
+		if !rest_decode(w, r, gameState_maxActionSize, &settings) {
+			return
+		}
+	}
+	if err := game.Start(ctx.Action("start"), settings); err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+func rest_input(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	input := rest_inputRequest{}
+	// an input has at most 4 bytes per character
+	body := http.MaxBytesReader(w, r.Body, int64(cfg.Input.MaxLength)*4+rest_jsonOverhead)
+	if err := json.NewDecoder(body).Decode(&input); err != nil {
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			rest_writeGameError(w, fmt.Errorf("%w: %w", games.ErrInputTooLong, err))
+			return
+		}
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := game.PlayerInput(ctx.Action("user_input"), ctx.UserID, input.Input); err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+// rest_transcribe accepts a recording as request body and returns its
+// transcript. The player can correct it and then send it to /game/input.
+func rest_transcribe(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	audio, err := io.ReadAll(http.MaxBytesReader(w, r.Body, rest_maxRecordingSize))
+	if err != nil {
+		rest_writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	transcript, err := game.Transcribe(ctx.Action("transcribe"), ctx.UserID, audio, r.Header.Get("Content-Type"))
+	if err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, rest_transcript{Transcript: transcript})
+}
+
+func rest_continue(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	if err := game.ContinueAfterRoll(ctx.Action("continue_after_roll")); err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+func rest_boundaries(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	boundaries := ai.Boundaries{}
+	if !rest_decode(w, r, rest_maxBodySize, &boundaries) {
+		return
+	}
+	if err := game.SetBoundaries(ctx.Action("set_boundaries"), ctx.UserID, boundaries); err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+// rest_xCard rewrites the last message of the narrator, the topic is optional.
+func rest_xCard(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	xCard := rest_xCardRequest{}
+	if r.ContentLength != 0 {
+		if !rest_decode(w, r, int64(cfg.Input.MaxLength)*4+rest_jsonOverhead, &xCard) {
+			return
+		}
+	}
+	if err := game.XCard(ctx.Action("x_card"), ctx.UserID, xCard.Topic); err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, game.View())
+}
+
+func rest_chat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	game, ok := rest_lookup(w, r)
+	if !ok {
+		return
+	}
+	offset, err := rest_queryInt(r, "offset", 0)
+	if err != nil {
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := rest_queryInt(r, "limit", rest_defaultChatLimit)
+	if err != nil {
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit = min(max(limit, 1), rest_maxChatLimit)
+	rest_writeJSON(w, http.StatusOK, game.Chat(offset, limit))
+}
+
+// rest_export downloads the story of a campaign. format is markdown, html or
+// epub, gm=true adds the memory of the AI and audio=true links the narration.
+func rest_export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	game, ok := rest_lookup(w, r)
+	if !ok {
+		return
+	}
+	ctx := context.From(w, r)
+	if !game.HasPlayer(ctx.UserID) {
+		rest_writeGameError(w, games.ErrUnknownPlayer)
+		return
+	}
+	query := r.URL.Query()
+	format, err := export.ParseFormat(cmp.Or(query.Get("format"), string(export.Markdown)))
+	if err != nil {
+		rest_writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts := export.Options{}
+	opts.GMAppendix, _ = strconv.ParseBool(query.Get("gm"))
+	opts.Audio, _ = strconv.ParseBool(query.Get("audio"))
+	if opts.Audio {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		opts.BaseURL = scheme + "://" + r.Host
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": export.Filename(game, format)}))
+	if err := export.Write(w, game, format, opts); err != nil {
+		slog.ErrorContext(ctx, "failed to export game", "game_id", game.ID, "format", format, "err", err)
+	}
+}
+
+// rest_archive downloads a campaign with all its files, see games.WriteArchive.
+func rest_archive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	game, ok := rest_lookup(w, r)
+	if !ok {
+		return
+	}
+	ctx := context.From(w, r)
+	if !game.HasPlayer(ctx.UserID) {
+		rest_writeGameError(w, games.ErrUnknownPlayer)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": game.ID + ".zip"}))
+	if err := game.WriteArchive(w); err != nil {
+		slog.ErrorContext(ctx, "failed to archive game", "game_id", game.ID, "err", err)
+	}
+}
+
+// rest_import adds the campaign of an archive (or a saved game file) sent as
+// request body. The caller joins it, so they can hand it over to their group.
+func rest_import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rest_methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	ctx := context.From(w, r)
+
+	// zip needs random access, so the archive is buffered in a temporary file
+	tmp, err := os.CreateTemp("", "gameslabor-import-*.zip")
+	if err != nil {
+		rest_writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, rest_maxArchiveSize))
+	if err != nil {
+		rest_writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	game, err := games.ImportArchive(ctx.Action("import_game"), tmp, size, ctx.UserID)
+	if err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusCreated, game.View())
+}
+
+// rest_savePoints lists the save points of a campaign (GET) or lets the host
+// create one (POST).
+func rest_savePoints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		game, ok := rest_lookup(w, r)
+		if !ok {
+			return
+		}
+		rest_writeJSON(w, http.StatusOK, game.View().SavePoints)
+	case http.MethodPost:
+		game, ctx, ok := rest_playerAction(w, r)
+		if !ok {
+			return
+		}
+		req := rest_savePointRequest{}
+		if !rest_decode(w, r, rest_maxBodySize, &req) {
+			return
+		}
+		info, err := game.CreateSavePoint(ctx.Action("create_save_point"), cfg.DataDir, ctx.UserID, req.Name)
+		if err != nil {
+			rest_writeGameError(w, err)
+			return
+		}
+		rest_writeJSON(w, http.StatusCreated, info)
+	default:
+		rest_methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// rest_fork starts a new game from the save point with the id in the query
+// parameter savepoint.
+func rest_fork(w http.ResponseWriter, r *http.Request) {
+	game, ctx, ok := rest_playerAction(w, r)
+	if !ok {
+		return
+	}
+	fork, err := games.Fork(ctx.Action("fork_game"), cfg.DataDir, game.ID, r.URL.Query().Get("savepoint"), ctx.UserID)
+	if err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusCreated, fork.View())
+}
+
+func rest_tree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rest_methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	tree, err := games.Tree(r.URL.Query().Get("id"))
+	if err != nil {
+		rest_writeGameError(w, err)
+		return
+	}
+	rest_writeJSON(w, http.StatusOK, tree)
+}
+
+// rest_playerAction checks the preconditions shared by all mutating game
+// endpoints: POST method, existing game and the caller being a player of it.
+func rest_playerAction(w http.ResponseWriter, r *http.Request) (*games.Game, *context.Context, bool) {
+	if r.Method != http.MethodPost {
+		rest_methodNotAllowed(w, http.MethodPost)
+		return nil, nil, false
+	}
+	game, ok := rest_lookup(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	ctx := context.From(w, r)
+	if !game.HasPlayer(ctx.UserID) {
+		rest_writeGameError(w, games.ErrUnknownPlayer)
+		return nil, nil, false
+	}
+	return game, ctx, true
+}
+
+func rest_lookup(w http.ResponseWriter, r *http.Request) (*games.Game, bool) {
+	game, err := games.Get(r.URL.Query().Get("id"))
+	if err != nil {
+		rest_writeGameError(w, err)
+		return nil, false
+	}
+	return game, true
+}
+
+func rest_queryInt(r *http.Request, key string, fallback int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// rest_decode decodes the JSON body of r into v and writes the error
+// otherwise, 413 for a body larger than limit.
+func rest_decode(w http.ResponseWriter, r *http.Request, limit int64, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v); err != nil {
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			rest_writeError(w, http.StatusRequestEntityTooLarge, err)
+			return false
+		}
+		rest_writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func rest_writeGameError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, games.ErrGameNotFound),
+		errors.Is(err, games.ErrSavePointNotFound):
+		rest_writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, games.ErrUnknownPlayer),
+		errors.Is(err, games.ErrNotHost):
+		rest_writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, games.ErrShuttingDown):
+		rest_writeError(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, games.ErrInvalidScenario),
+		errors.Is(err, games.ErrInvalidTTSBackend),
+		errors.Is(err, games.ErrInvalidLanguage),
+		errors.Is(err, games.ErrInvalidArchive),
+		errors.Is(err, games.ErrArchiveVersion),
+		errors.Is(err, games.ErrInvalidSavePoint),
+		errors.Is(err, ai.ErrInvalidModelSettings),
+		errors.Is(err, ai.ErrInvalidBoundaries),
+		errors.Is(err, games.ErrEmptyInput):
+		rest_writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ai.ErrAssetQuotaExceeded),
+		errors.Is(err, games.ErrInputTooLong):
+		rest_writeError(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, games.ErrRateLimited):
+		rest_writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, ai.ErrEmptyTranscript):
+		rest_writeError(w, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, games.ErrNotInit),
+		errors.Is(err, games.ErrNotRunning),
+		errors.Is(err, games.ErrNotAcceptingInput),
+		errors.Is(err, games.ErrNoRoll),
+		errors.Is(err, games.ErrNoNarration):
+		rest_writeError(w, http.StatusConflict, err)
+	default:
+		rest_writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func rest_writeError(w http.ResponseWriter, status int, err error) {
+	rest_writeJSON(w, status, rest_error{Error: err.Error()})
+}
+
+func rest_methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	for _, m := range allowed {
+		w.Header().Add("Allow", m)
+	}
+	rest_writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+func rest_writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write json response", "err", err)
+	}
+}
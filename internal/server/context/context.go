@@ -2,6 +2,7 @@ package context
 
 import (
 	"context"
+	"gameslabor/internal/logging"
 	"net/http"
 	"strings"
 	"time"
@@ -65,6 +66,13 @@ func getPathVariablesFromRequest(ctx *Context, r *http.Request) {
 	}
 }
 
+// Action returns the context for a game action triggered by this request.
+// It outlives the request and carries the user id and a new correlation id
+// for logging.
+func (ctx *Context) Action(action string) context.Context {
+	return logging.NewTurn(logging.With(ctx, "user_id", ctx.UserID), action)
+}
+
 func (ctx *Context) Deadline() (deadline time.Time, ok bool) {
 	return ctx.base.Deadline()
 }
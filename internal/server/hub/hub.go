@@ -1,28 +1,52 @@
 package hub
 
 import (
-	"github.com/gorilla/websocket"
+	"context"
+	"gameslabor/internal/metrics"
+	"log/slog"
 )
 
+// broadcastQueueSize is the number of messages that can wait for the hub
+// before Broadcast blocks.
+const broadcastQueueSize = 256
+
 var (
 	clients    = make(map[string]map[*Client]bool)
-	broadcast  = make(chan Message)
+	broadcast  = make(chan Message, broadcastQueueSize)
 	register   = make(chan *Client)
 	unregister = make(chan *Client)
 	stop       = make(chan struct{})
 )
 
+// Subscriber receives the messages broadcasted for a game.
+// Send is called from the hub goroutine and must not block for long.
+type Subscriber interface {
+	Send(data any) error
+}
+
+// Closer is implemented by subscribers that hold a connection which should be
+// closed when the hub stops or drops the subscriber.
+type Closer interface {
+	Close() error
+}
+
 type Client struct {
-	conn *websocket.Conn
-	id   string
+	sub Subscriber
+	id  string
 }
 
 type Message struct {
 	ID   string
 	Data any
+	// client is the only subscriber the message is sent to if set, see
+	// Client.Send.
+	client *Client
+	// flushed is closed by the hub instead of sending the message, see Flush.
+	flushed chan struct{}
 }
 
 func init() {
+	metrics.QueueDepth("hub", "broadcast", func() int { return len(broadcast) })
 	go RunHub()
 }
 
@@ -34,39 +58,128 @@ func RunHub() {
 				clients[client.id] = make(map[*Client]bool)
 			}
 			clients[client.id][client] = true
+			metrics.HubSubscribers.Inc()
 
 		case client := <-unregister:
-			if _, ok := clients[client.id]; ok {
-				delete(clients[client.id], client)
+			if subscribers, ok := clients[client.id]; ok {
+				if _, ok := subscribers[client]; ok {
+					delete(subscribers, client)
+					metrics.HubSubscribers.Dec()
+				}
+				if len(subscribers) == 0 {
+					delete(clients, client.id)
+				}
 			}
 
 		case message := <-broadcast:
-			if subscribers, ok := clients[message.ID]; ok {
-				for client := range subscribers {
-					client.conn.WriteJSON(message.Data)
+			if message.flushed != nil {
+				close(message.flushed)
+				continue
+			}
+			metrics.HubMessages.Inc()
+			if message.client != nil {
+				if clients[message.ID][message.client] {
+					send(message.client, message.Data)
 				}
+				continue
+			}
+			for client := range clients[message.ID] {
+				send(client, message.Data)
 			}
 
 		case <-stop:
+			for _, subscribers := range clients {
+				for client := range subscribers {
+					if closer, ok := client.sub.(Closer); ok {
+						_ = closer.Close()
+					}
+				}
+			}
+			clear(clients)
+			metrics.HubSubscribers.Set(0)
 			return
 		}
 	}
 }
 
+// send sends data to a subscriber of the hub. A subscriber that fails is
+// dropped and its connection closed, so its client notices and reconnects.
+func send(client *Client, data any) {
+	err := client.sub.Send(data)
+	if err == nil {
+		return
+	}
+	slog.Warn("failed to send to subscriber", "game_id", client.id, "err", err)
+	subscribers := clients[client.id]
+	delete(subscribers, client)
+	if len(subscribers) == 0 {
+		delete(clients, client.id)
+	}
+	metrics.HubSubscribers.Dec()
+	if closer, ok := client.sub.(Closer); ok {
+		_ = closer.Close()
+	}
+}
+
 func StopHub() {
 	close(stop)
 }
 
-func Register(id string, conn *websocket.Conn) *Client {
-	client := &Client{conn: conn, id: id}
-	register <- client
+// The channel operations below select on stop so callers don't block forever
+// once the hub is stopped during shutdown.
+
+func Register(id string, sub Subscriber) *Client {
+	client := &Client{sub: sub, id: id}
+	select {
+	case register <- client:
+	case <-stop:
+		if closer, ok := sub.(Closer); ok {
+			_ = closer.Close()
+		}
+	}
 	return client
 }
 
 func (client *Client) Close() {
-	unregister <- client
+	select {
+	case unregister <- client:
+	case <-stop:
+	}
+}
+
+// Send sends data to the client only, in order with the messages broadcasted
+// to its game, e.g. the full state after it registered.
+func (client *Client) Send(data any) {
+	select {
+	case broadcast <- Message{ID: client.id, Data: data, client: client}:
+	case <-stop:
+	}
 }
 
 func Broadcast(id string, data any) {
-	broadcast <- Message{ID: id, Data: data}
+	select {
+	case broadcast <- Message{ID: id, Data: data}:
+	case <-stop:
+	}
+}
+
+// Flush waits until the hub has sent the messages broadcasted before, e.g. a
+// notice before the hub is stopped. It returns early if ctx expires.
+func Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case broadcast <- Message{flushed: flushed}:
+	case <-stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
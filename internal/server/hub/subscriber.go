@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket writes every message as JSON text frame to the connection.
+type WebSocket struct {
+	conn *websocket.Conn
+	mut  sync.Mutex
+}
+
+func NewWebSocket(conn *websocket.Conn) *WebSocket {
+	return &WebSocket{conn: conn}
+}
+
+func (ws *WebSocket) Send(data any) error {
+	ws.mut.Lock()
+	defer ws.mut.Unlock()
+	return ws.conn.WriteJSON(data)
+}
+
+func (ws *WebSocket) Close() error {
+	ws.mut.Lock()
+	defer ws.mut.Unlock()
+	_ = ws.conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "closed by server"),
+	)
+	return ws.conn.Close()
+}
+
+// sseBufferSize is the number of messages an SSE subscriber may lag behind
+// before it is dropped.
+const sseBufferSize = 64
+
+var ErrSubscriberTooSlow = errors.New("subscriber too slow")
+
+// SSE queues every message as JSON encoded event data.
+// The HTTP handler owning the stream drains Events and writes them out,
+// because the response writer must not be used from the hub goroutine.
+type SSE struct {
+	events chan []byte
+	once   sync.Once
+}
+
+func NewSSE() *SSE {
+	return &SSE{events: make(chan []byte, sseBufferSize)}
+}
+
+func (sse *SSE) Send(data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	select {
+	case sse.events <- b:
+		return nil
+	default:
+		sse.close()
+		return ErrSubscriberTooSlow
+	}
+}
+
+// Events is closed when the subscriber was dropped by the hub.
+func (sse *SSE) Events() <-chan []byte {
+	return sse.events
+}
+
+func (sse *SSE) Close() error {
+	sse.close()
+	return nil
+}
+
+func (sse *SSE) close() {
+	sse.once.Do(func() { close(sse.events) })
+}
@@ -3,6 +3,7 @@ import {
   memo,
   type SetStateAction,
   useEffect,
+  useRef,
   useState,
 } from "react";
 import {
@@ -11,19 +12,52 @@ import {
   startGame,
   userInput,
   continueAfterRoll,
+  transcribe,
+  createSavePoint,
+  forkGame,
+  fetchTree,
+  fetchModels,
+  setBoundaries,
+  xCard,
 } from "./gamestate.ts";
 import {
+  type ChatMessage,
   chatMessageId,
   descriptionEquals,
   DiceRoll,
   GameState,
+  type Language,
+  type Model,
+  type ModelSettings,
   type PlayerData,
+  type Scenario,
+  type TreeNode,
 } from "./types.ts";
 import { myUserId, seededRandomCharacter, stringToColor } from "./util.ts";
 import { QRCodeSVG } from "qrcode.react";
 
 interface Props {
-  scenarios: { title: string; id: string; image: string }[];
+  // scenarios are the scenarios by language code
+  scenarios: Record<string, Scenario[]>;
+  languages: Language[];
+  default_language: string;
+  tts_backends: string[];
+}
+
+// customScenarioId marks the scenario written by the players in the lobby.
+const customScenarioId = "custom";
+
+function emptyCustomScenario(): Scenario {
+  return {
+    id: customScenarioId,
+    title: "",
+    description: "",
+    tone: "",
+    themes: [],
+    npc_types: [],
+    dangers: [],
+    builtin: false,
+  };
 }
 
 export function Game(props: Props) {
@@ -41,12 +75,175 @@ export function Game(props: Props) {
 function RunningGame() {
   return (
     <>
+      <RunningGameExport />
+      <RunningGameSavePoints />
       <RunningGameChatHistory />
       <RunningGameInput />
     </>
   );
 }
 
+// RunningGameExport links the downloads of the story so far.
+function RunningGameExport() {
+  const g = useGameData();
+  const formats = [
+    ["markdown", "Markdown"],
+    ["html", "HTML"],
+    ["epub", "EPUB"],
+  ];
+  return (
+    <p className="max-w-5xl mx-auto px-4 mt-4 text-sm text-stone-500">
+      Geschichte herunterladen:
+      {formats.map(([format, label]) => (
+        <a
+          key={format}
+          className="ml-4 underline hover:text-stone-300"
+          href={`/api/game/export?id=${encodeURIComponent(g.id)}&format=${format}&audio=true`}
+          download
+        >
+          {label}
+        </a>
+      ))}
+      <a
+        className="ml-4 underline hover:text-stone-300"
+        href={`/api/game/archive?id=${encodeURIComponent(g.id)}`}
+        download
+      >
+        Archiv
+      </a>
+    </p>
+  );
+}
+
+// RunningGameSavePoints lets the host save the campaign and fork it from a
+// save point. Everyone sees the branch tree of the campaign.
+function RunningGameSavePoints() {
+  const g = useGameData();
+  const [name, setName] = useState("");
+  const [tree, setTree] = useState<TreeNode | null>(null);
+  const isHost =
+    g.host === myUserId || (g.host === "" && myUserId in g.players);
+
+  useEffect(() => {
+    fetchTree().then(setTree);
+  }, [g.id, g.save_points.length]);
+
+  const fork = async (savePointId: string) => {
+    const id = await forkGame(savePointId);
+    if (id) {
+      location.href = `/game?id=${encodeURIComponent(id)}`;
+    }
+  };
+
+  if (!isHost && !g.lineage && (tree?.children.length ?? 0) === 0) {
+    return null;
+  }
+
+  return (
+    <details className="max-w-5xl mx-auto px-4 mt-2 text-sm text-stone-500">
+      <summary className="cursor-pointer hover:text-stone-300">
+        Speicherpunkte und Zweige
+      </summary>
+      {g.lineage ? (
+        <p className="mt-2">
+          Abgezweigt von{" "}
+          <a
+            className="underline hover:text-stone-300"
+            href={`/game?id=${encodeURIComponent(g.lineage.parent_id)}`}
+          >
+            {g.lineage.save_point_name}
+          </a>
+        </p>
+      ) : null}
+      {isHost ? (
+        <form
+          className="flex flex-row gap-4 mt-2"
+          onSubmit={async (ev) => {
+            ev.preventDefault();
+            if (!name.trim()) {
+              return;
+            }
+            if (await createSavePoint(name)) {
+              setName("");
+            }
+          }}
+        >
+          <input
+            type="text"
+            className="grow p-2 bg-stone-800 rounded-md border border-solid border-transparent focus:border-stone-400"
+            placeholder="Name des Speicherpunkts"
+            maxLength={100}
+            value={name}
+            onChange={(ev) => setName(ev.target.value)}
+          />
+          <button type="submit" className="btn" disabled={!name.trim()}>
+            Speichern
+          </button>
+        </form>
+      ) : null}
+      {g.save_points.length > 0 ? (
+        <ul className="mt-2">
+          {g.save_points.map((sp) => (
+            <li key={sp.id} className="flex flex-row gap-4 items-center mt-1">
+              <span className="grow">
+                {sp.name}{" "}
+                <span className="text-stone-600">
+                  (Runde {sp.turn}, {new Date(sp.created).toLocaleString()})
+                </span>
+              </span>
+              {isHost ? (
+                <button
+                  type="button"
+                  className="underline hover:text-stone-300"
+                  onClick={() => fork(sp.id)}
+                >
+                  Abzweigen
+                </button>
+              ) : null}
+            </li>
+          ))}
+        </ul>
+      ) : null}
+      {tree && tree.children.length > 0 ? (
+        <>
+          <p className="mt-4">Zweige der Kampagne</p>
+          <ul>
+            <BranchTree node={tree} current={g.id} />
+          </ul>
+        </>
+      ) : null}
+    </details>
+  );
+}
+
+function BranchTree(props: { node: TreeNode; current: string }) {
+  const { node, current } = props;
+  return (
+    <li className="ml-4 mt-1">
+      {node.id === current ? (
+        <span className="text-stone-300">
+          {node.lineage?.save_point_name ?? "Ursprung"} (hier)
+        </span>
+      ) : (
+        <a
+          className="underline hover:text-stone-300"
+          href={`/game?id=${encodeURIComponent(node.id)}`}
+        >
+          {node.lineage?.save_point_name ?? "Ursprung"}
+        </a>
+      )}{" "}
+      <span className="text-stone-600">Runde {node.turn}</span>
+      {node.children.length > 0 ? (
+        <ul>
+          {node.children.map((child) => (
+            <BranchTree key={child.id} node={child} current={current} />
+          ))}
+        </ul>
+      ) : null}
+    </li>
+  );
+}
+
 function RunningGameChatHistory() {
   const g = useGameData();
   useEffect(() => {
@@ -64,7 +261,7 @@ function RunningGameChatHistory() {
   }
   return (
     <ul className="max-w-5xl mx-auto pb-64">
-      {g.ai.chat_history.map((m) => (
+      {g.ai.chat_history.map((m, i) => (
         <li
           key={chatMessageId(m)}
           className="chat-message block p-4 my-4 border border-stone-700 border-solid rounded-md"
@@ -76,16 +273,27 @@ function RunningGameChatHistory() {
           ) : (
             <p className="text-stone-50 text-xl">
               Erzähler
-              {m.audio ? (
-                <audio className="h-[1em] ml-4 inline-block" controls>
-                  <source src={m.audio} type="audio/ogg" />
-                </audio>
+              {narratorAudio(m).length > 0 ? (
+                <NarratorAudio segments={narratorAudio(m)} />
               ) : (
                 <span className="text-xs ml-4">Audio wird generiert...</span>
               )}
             </p>
           )}
+          {m.role === "model" && m.image ? (
+            <img
+              src={m.image}
+              alt=""
+              className="block mt-4 w-full rounded-md aspect-video object-cover"
+              draggable={false}
+            />
+          ) : null}
           <p className="mt-4 text-stone-50">{m.message}</p>
+          {m.role === "model" &&
+          i === g.ai.chat_history.length - 1 &&
+          (g.accepting_input || g.roll) ? (
+            <XCardButton />
+          ) : null}
         </li>
       ))}
       {g.roll ? (
@@ -101,6 +309,67 @@ function RunningGameChatHistory() {
   );
 }
 
+// XCardButton rejects the last message of the narrator. Naming the topic is
+// optional, nobody has to explain why.
+function XCardButton() {
+  return (
+    <button
+      type="button"
+      className="btn mt-4 text-sm"
+      title="X-Karte: Die Nachricht wird ohne diesen Inhalt neu geschrieben"
+      onClick={() => {
+        const topic = prompt(
+          "X-Karte: Die Nachricht wird neu geschrieben. Optional: Was soll vermieden werden?",
+        );
+        if (topic !== null) {
+          xCard(topic);
+        }
+      }}
+    >
+      ✕ X-Karte
+    </button>
+  );
+}
+
+// narratorAudio returns the audio files of a message in the order they are
+// played, none while they are generated. A message spoken by several voices
+// only has audio_segments.
+function narratorAudio(m: ChatMessage): string[] {
+  if (m.role === "model" && m.audio_segments && m.audio_segments.length > 0) {
+    return m.audio_segments;
+  }
+  return m.audio ? [m.audio] : [];
+}
+
+// NarratorAudio plays the segments of a message one after another,
+// every segment can be spoken by a different voice.
+function NarratorAudio(props: { segments: string[] }) {
+  const [index, setIndex] = useState(0);
+  const [playing, setPlaying] = useState(false);
+  return (
+    <audio
+      className="h-[1em] ml-4 inline-block"
+      controls
+      src={props.segments[index]}
+      autoPlay={playing && index > 0}
+      onPlay={() => setPlaying(true)}
+      onPause={(ev) => {
+        if (!ev.currentTarget.ended) {
+          setPlaying(false);
+        }
+      }}
+      onEnded={() => {
+        if (index + 1 < props.segments.length) {
+          setIndex(index + 1);
+        } else {
+          setIndex(0);
+          setPlaying(false);
+        }
+      }}
+    />
+  );
+}
+
 function RunningGameInput() {
   const g = useGameData();
   const [value, setValue] = useState("");
@@ -125,6 +394,13 @@ function RunningGameInput() {
           setValue(ev.target.value);
         }}
       />
+      {"MediaRecorder" in window ? (
+        <RecordButton
+          onTranscript={(transcript) =>
+            setValue(value ? `${value} ${transcript}` : transcript)
+          }
+        />
+      ) : null}
       <button
         type="submit"
         className="btn"
@@ -143,6 +419,56 @@ function RunningGameInput() {
   );
 }
 
+// RecordButton records speech while it is held. The transcript is put into
+// the input field, so the player can correct it before sending.
+function RecordButton(props: { onTranscript: (transcript: string) => void }) {
+  const [state, setState] = useState<"idle" | "recording" | "transcribing">(
+    "idle",
+  );
+  const recorder = useRef<MediaRecorder | null>(null);
+
+  const start = async () => {
+    if (state !== "idle") {
+      return;
+    }
+    const stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+    const chunks: Blob[] = [];
+    const r = new MediaRecorder(stream);
+    r.addEventListener("dataavailable", (ev) => chunks.push(ev.data));
+    r.addEventListener("stop", async () => {
+      stream.getTracks().forEach((track) => track.stop());
+      setState("transcribing");
+      const transcript = await transcribe(
+        new Blob(chunks, { type: r.mimeType }),
+      );
+      setState("idle");
+      if (transcript) {
+        props.onTranscript(transcript);
+      }
+    });
+    r.start();
+    recorder.current = r;
+    setState("recording");
+  };
+  const stop = () => {
+    recorder.current?.stop();
+    recorder.current = null;
+  };
+
+  return (
+    <button
+      type="button"
+      className={`btn ${state === "recording" ? "outline-2 outline-solid outline-red-400" : ""}`}
+      disabled={state === "transcribing"}
+      onPointerDown={start}
+      onPointerUp={stop}
+      onPointerLeave={stop}
+    >
+      {state === "transcribing" ? "..." : "🎤"}
+    </button>
+  );
+}
+
 const Roll = memo(
   function Roll(props: { roll: DiceRoll | null }) {
     useEffect(() => {
@@ -210,9 +536,7 @@ const Roll = memo(
 );
 
 function InitScenarioButton(props: {
-  title: string;
-  imgSrc: string;
-  id: string;
+  scenario: Scenario;
   selected: boolean;
   onClick?: () => void;
 }) {
@@ -222,13 +546,38 @@ function InitScenarioButton(props: {
       className={`w-72 block pointer-events-auto cursor-pointer group-hover:opacity-50 hover:opacity-100 transition-opacity border border-solid rounded-md p-4 ${props.selected ? "bg-stone-900 border-stone-500 text-white" : "border-stone-700"}`}
       onClick={props.onClick}
     >
-      <img
-        src={props.imgSrc}
-        alt=""
-        className="block rounded-md aspect-[3/2]"
-        draggable={false}
-      />
-      <p className="block mt-4">{props.title}</p>
+      {props.scenario.image ? (
+        <img
+          src={props.scenario.image}
+          alt=""
+          className="block rounded-md aspect-[3/2]"
+          draggable={false}
+        />
+      ) : null}
+      <p className="block mt-4">{props.scenario.title}</p>
+      {props.scenario.description ? (
+        <p className="block mt-2 text-sm text-stone-400">
+          {props.scenario.description}
+        </p>
+      ) : null}
+      {props.scenario.content_warnings &&
+      props.scenario.content_warnings.length > 0 ? (
+        <p className="block mt-2 text-xs text-orange-300">
+          Inhaltswarnungen: {props.scenario.content_warnings.join(", ")}
+        </p>
+      ) : null}
+      {props.scenario.dice || props.scenario.durations?.length ? (
+        <p className="block mt-2 text-xs text-stone-500">
+          {[
+            props.scenario.dice,
+            props.scenario.durations
+              ?.map((d) => lengthToText(d).split(" (")[0])
+              .join(" / "),
+          ]
+            .filter(Boolean)
+            .join(" · ")}
+        </p>
+      ) : null}
     </button>
   );
 }
@@ -269,9 +618,30 @@ const descriptionTranslations = new Map([
 ]);
 
 function Init(props: Props) {
-  const [selectedScenario, setSelectedScenario] = useState<string | null>(null);
-  const [violenceLevel, setViolenceLevel] = useState<number>(1);
-  const [length, setLength] = useState<number>(1);
+  const g = useGameData();
+  const [selectedScenario, setSelectedScenario] = useState<string | null>(
+    g.settings.custom_scenario
+      ? customScenarioId
+      : g.settings.scenario || null,
+  );
+  const [customScenario, setCustomScenario] = useState<Scenario>(
+    g.settings.custom_scenario ?? emptyCustomScenario(),
+  );
+  const [violenceLevel, setViolenceLevel] = useState<number>(
+    g.settings.scenario || g.settings.custom_scenario
+      ? g.settings.violence_level
+      : 1,
+  );
+  const [length, setLength] = useState<number>(
+    g.settings.scenario || g.settings.custom_scenario ? g.settings.duration : 1,
+  );
+  const [ttsBackend, setTtsBackend] = useState<string>(
+    g.settings.tts_backend,
+  );
+  const [language, setLanguage] = useState<string>(
+    g.settings.language || props.default_language,
+  );
+  const [models, setModels] = useState<ModelSettings>(g.settings.models);
 
   return (
     <div className="max-w-7xl px-4 justify-center w-fit mx-auto block my-8 pb-64">
@@ -281,21 +651,38 @@ function Init(props: Props) {
       />
       <InitPlayers />
       <InitScenario
-        scenarios={props.scenarios}
+        scenarios={props.scenarios[language] ?? []}
+        languages={props.languages}
+        language={language}
+        setLanguage={setLanguage}
         selectedScenario={selectedScenario}
         setSelectedScenario={setSelectedScenario}
+        customScenario={customScenario}
+        setCustomScenario={setCustomScenario}
+        setLength={setLength}
       />
       <InitSettings
         violenceLevel={violenceLevel}
         setViolenceLevel={setViolenceLevel}
         length={length}
         setLength={setLength}
+        ttsBackends={props.tts_backends}
+        ttsBackend={ttsBackend}
+        setTtsBackend={setTtsBackend}
       />
+      <InitBoundaries />
+      <InitModels models={models} setModels={setModels} />
 
       <InitStart
         selectedScenario={selectedScenario}
+        customScenario={
+          selectedScenario === customScenarioId ? customScenario : null
+        }
         violenceLevel={violenceLevel}
         length={length}
+        ttsBackend={ttsBackend}
+        language={language}
+        models={models}
       />
     </div>
   );
@@ -303,8 +690,12 @@ function Init(props: Props) {
 
 interface InitStartProps {
   selectedScenario: string | null;
+  customScenario: Scenario | null;
   violenceLevel: number;
   length: number;
+  ttsBackend: string;
+  language: string;
+  models: ModelSettings;
 }
 
 function InitStart(props: InitStartProps) {
@@ -318,6 +709,10 @@ function InitStart(props: InitStartProps) {
       !p.description.appearance ||
       !p.description.origin,
   ).length;
+  const customScenarioComplete =
+    !props.customScenario ||
+    (props.customScenario.title.trim() !== "" &&
+      props.customScenario.description.trim() !== "");
   return (
     <>
       <p className="block text-xl font-bold mb-4 mt-16">
@@ -326,6 +721,11 @@ function InitStart(props: InitStartProps) {
       {!props.selectedScenario && (
         <p className="my-4 text-orange-400">Bitte wähle eine Szenario</p>
       )}
+      {props.customScenario && !customScenarioComplete && (
+        <p className="my-4 text-orange-400">
+          Dein Szenario braucht einen Titel und eine Beschreibung
+        </p>
+      )}
       {playersWithoutDescription > 0 && (
         <p className="my-4 text-orange-400">
           Es haben noch nicht alle Spieler eine Charakterbeschreibung angegeben
@@ -334,13 +734,21 @@ function InitStart(props: InitStartProps) {
       <button
         type="submit"
         className="btn"
-        disabled={!props.selectedScenario || playersWithoutDescription > 0}
+        disabled={
+          !props.selectedScenario ||
+          !customScenarioComplete ||
+          playersWithoutDescription > 0
+        }
         onClick={() => {
           if (props.selectedScenario) {
             startGame(
               props.selectedScenario,
+              props.customScenario,
               props.violenceLevel,
               props.length,
+              props.ttsBackend,
+              props.language,
+              props.models,
             );
           }
         }}
@@ -434,9 +842,16 @@ function InitPlayers() {
   );
 }
 
-interface InitScenarioProps extends Props {
+interface InitScenarioProps {
+  scenarios: Scenario[];
+  languages: Language[];
+  language: string;
+  setLanguage: Dispatch<SetStateAction<string>>;
   selectedScenario: string | null;
   setSelectedScenario: Dispatch<SetStateAction<string | null>>;
+  customScenario: Scenario;
+  setCustomScenario: Dispatch<SetStateAction<Scenario>>;
+  setLength: Dispatch<SetStateAction<number>>;
 }
 
 function InitScenario(props: InitScenarioProps) {
@@ -446,28 +861,127 @@ function InitScenario(props: InitScenarioProps) {
       <p className="my-4 text-stone-500">
         Dies bestimmt das grundlegende Setting deiner Kampagne.
       </p>
+      {props.languages.length > 1 && (
+        <label className="block my-4">
+          <p>Sprache der Kampagne</p>
+          <select
+            className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+            value={props.language}
+            onChange={(e) => {
+              props.setLanguage(e.target.value);
+            }}
+          >
+            {props.languages.map((language) => (
+              <option key={language.code} value={language.code}>
+                {language.name}
+              </option>
+            ))}
+          </select>
+        </label>
+      )}
       <div className="flex flex-row flex-wrap gap-8 group pointer-events-none">
         {props.scenarios.map((scenario) => (
           <InitScenarioButton
             key={scenario.id}
-            title={scenario.title}
-            imgSrc={scenario.image}
-            id={scenario.id}
+            scenario={scenario}
             selected={props.selectedScenario === scenario.id}
-            onClick={() => props.setSelectedScenario(scenario.id)}
+            onClick={() => {
+              props.setSelectedScenario(scenario.id);
+              // preselect the length the scenario is made for
+              if (scenario.durations && scenario.durations.length > 0) {
+                props.setLength(scenario.durations[0]);
+              }
+            }}
           />
         ))}
+        <button
+          type="button"
+          className={`w-72 block pointer-events-auto cursor-pointer group-hover:opacity-50 hover:opacity-100 transition-opacity border border-solid rounded-md p-4 ${props.selectedScenario === customScenarioId ? "bg-stone-900 border-stone-500 text-white" : "border-stone-700"}`}
+          onClick={() => props.setSelectedScenario(customScenarioId)}
+        >
+          <p className="block">Eigenes Szenario</p>
+        </button>
       </div>
+      {props.selectedScenario === customScenarioId && (
+        <InitCustomScenario
+          scenario={props.customScenario}
+          setScenario={props.setCustomScenario}
+        />
+      )}
     </>
   );
 }
 
+// InitCustomScenario lets the players write their own scenario. Lists are
+// edited as one entry per line.
+function InitCustomScenario(props: {
+  scenario: Scenario;
+  setScenario: Dispatch<SetStateAction<Scenario>>;
+}) {
+  const textField = (
+    key: "title" | "description" | "tone" | "starting_location",
+    label: string,
+    multiline: boolean,
+  ) => (
+    <label className="block my-4 bg-stone-800 p-2 border border-solid rounded-md border-stone-700 has-focus:border-stone-400">
+      {label}
+      {multiline ? (
+        <textarea
+          className="block w-full min-h-32"
+          value={props.scenario[key] ?? ""}
+          onChange={(ev) =>
+            props.setScenario({ ...props.scenario, [key]: ev.target.value })
+          }
+        />
+      ) : (
+        <input
+          className="block w-full"
+          value={props.scenario[key] ?? ""}
+          onChange={(ev) =>
+            props.setScenario({ ...props.scenario, [key]: ev.target.value })
+          }
+        />
+      )}
+    </label>
+  );
+  const listField = (key: "themes" | "npc_types" | "dangers", label: string) => (
+    <label className="block my-4 bg-stone-800 p-2 border border-solid rounded-md border-stone-700 has-focus:border-stone-400">
+      {label} <span className="text-stone-500">(eins pro Zeile)</span>
+      <textarea
+        className="block w-full min-h-24"
+        value={(props.scenario[key] ?? []).join("\n")}
+        onChange={(ev) =>
+          props.setScenario({
+            ...props.scenario,
+            [key]: ev.target.value.split("\n"),
+          })
+        }
+      />
+    </label>
+  );
+  return (
+    <div className="block max-w-3xl mt-8">
+      {textField("title", "Titel", false)}
+      {textField("description", "Beschreibung", true)}
+      {textField("tone", "Welt und Ton", false)}
+      {textField("starting_location", "Startort", false)}
+      {listField("themes", "Themen")}
+      {listField("npc_types", "Wichtige NSC-Typen")}
+      {listField("dangers", "Gefahren")}
+    </div>
+  );
+}
+
 interface InitSettingsProps {
   violenceLevel: number;
   setViolenceLevel: Dispatch<SetStateAction<number>>;
 
   length: number;
   setLength: Dispatch<SetStateAction<number>>;
+
+  ttsBackends: string[];
+  ttsBackend: string;
+  setTtsBackend: Dispatch<SetStateAction<string>>;
 }
 
 function InitSettings(props: InitSettingsProps) {
@@ -507,10 +1021,193 @@ function InitSettings(props: InitSettingsProps) {
           }}
         />
       </label>
+      {props.ttsBackends.length > 1 && (
+        <label className="block my-4">
+          <p>Sprachausgabe</p>
+          <select
+            className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+            value={props.ttsBackend}
+            onChange={(e) => {
+              props.setTtsBackend(e.target.value);
+            }}
+          >
+            <option value="">Server-Standard</option>
+            {props.ttsBackends.map((backend) => (
+              <option key={backend} value={backend}>
+                {backend}
+              </option>
+            ))}
+          </select>
+        </label>
+      )}
     </>
   );
 }
 
+// InitBoundaries edits the lines (topics that never appear) and veils (topics
+// that only happen off-screen) of the table. Every player can change them, the
+// saved ones are shared with everyone.
+function InitBoundaries() {
+  const g = useGameData();
+  const saved = g.settings.boundaries;
+  const [lines, setLines] = useState((saved.lines ?? []).join("\n"));
+  const [veils, setVeils] = useState((saved.veils ?? []).join("\n"));
+  const split = (text: string) =>
+    text
+      .split("\n")
+      .map((entry) => entry.trim())
+      .filter((entry) => entry !== "");
+  const changed =
+    split(lines).join("\n") !== (saved.lines ?? []).join("\n") ||
+    split(veils).join("\n") !== (saved.veils ?? []).join("\n");
+
+  return (
+    <>
+      <p className="block text-xl font-bold mb-4 mt-16">Grenzen</p>
+      <p className="my-4 text-stone-500">
+        Linien kommen in der Geschichte nie vor. Schleier dürfen geschehen,
+        aber nur abseits der Szene. Während des Spiels kann jeder mit der
+        X-Karte die letzte Nachricht neu schreiben lassen.
+      </p>
+      <div className="block max-w-3xl">
+        <label className="block my-4 bg-stone-800 p-2 border border-solid rounded-md border-stone-700 has-focus:border-stone-400">
+          Linien <span className="text-stone-500">(eine pro Zeile)</span>
+          <textarea
+            className="block w-full min-h-24"
+            value={lines}
+            onChange={(ev) => setLines(ev.target.value)}
+          />
+        </label>
+        <label className="block my-4 bg-stone-800 p-2 border border-solid rounded-md border-stone-700 has-focus:border-stone-400">
+          Schleier <span className="text-stone-500">(einer pro Zeile)</span>
+          <textarea
+            className="block w-full min-h-24"
+            value={veils}
+            onChange={(ev) => setVeils(ev.target.value)}
+          />
+        </label>
+      </div>
+      <button
+        type="submit"
+        className={`btn ${changed ? "outline-2 outline-solid outline-orange-400" : ""}`}
+        onClick={() => {
+          setBoundaries(split(lines), split(veils));
+        }}
+      >
+        Speichern
+      </button>
+    </>
+  );
+}
+
+interface InitModelsProps {
+  models: ModelSettings;
+  setModels: Dispatch<SetStateAction<ModelSettings>>;
+}
+
+// InitModels selects the models and sampling of the campaign, empty fields
+// use the server config.
+function InitModels(props: InitModelsProps) {
+  const [available, setAvailable] = useState<Model[]>([]);
+  useEffect(() => {
+    fetchModels().then(setAvailable);
+  }, []);
+
+  const set = (update: Partial<ModelSettings>) => {
+    props.setModels((models) => ({ ...models, ...update }));
+  };
+  const optionalNumber = (value: string) =>
+    value.trim() === "" ? undefined : Number(value);
+
+  return (
+    <details className="my-4">
+      <summary className="cursor-pointer">Modell</summary>
+      {available.length > 0 && (
+        <>
+          <label className="block my-4">
+            <p>Modell für den Start</p>
+            <select
+              className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+              value={props.models.thinking_model ?? ""}
+              onChange={(e) => {
+                set({ thinking_model: e.target.value || undefined });
+              }}
+            >
+              <option value="">Server-Standard</option>
+              {available.map((model) => (
+                <option key={model.name} value={model.name}>
+                  {model.display_name || model.name}
+                </option>
+              ))}
+            </select>
+          </label>
+          <label className="block my-4">
+            <p>Modell für die Fortsetzung</p>
+            <select
+              className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+              value={props.models.model ?? ""}
+              onChange={(e) => {
+                set({ model: e.target.value || undefined });
+              }}
+            >
+              <option value="">Server-Standard</option>
+              {available.map((model) => (
+                <option key={model.name} value={model.name}>
+                  {model.display_name || model.name}
+                </option>
+              ))}
+            </select>
+          </label>
+        </>
+      )}
+      <label className="block my-4">
+        <p>Temperatur (0 bis 2)</p>
+        <input
+          className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+          type="number"
+          min={0}
+          max={2}
+          step={0.1}
+          placeholder="Server-Standard"
+          value={props.models.temperature ?? ""}
+          onChange={(e) => {
+            set({ temperature: optionalNumber(e.target.value) });
+          }}
+        />
+      </label>
+      <label className="block my-4">
+        <p>Denkbudget in Tokens (-1 automatisch, 0 aus)</p>
+        <input
+          className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+          type="number"
+          min={-1}
+          max={32768}
+          step={1}
+          placeholder="Server-Standard"
+          value={props.models.thinking_budget ?? ""}
+          onChange={(e) => {
+            set({ thinking_budget: optionalNumber(e.target.value) });
+          }}
+        />
+      </label>
+      <label className="block my-4">
+        <p>Maximale Tokens pro Antwort</p>
+        <input
+          className="block w-full max-w-80 p-2 bg-stone-800 rounded-md"
+          type="number"
+          min={0}
+          step={1}
+          placeholder="Server-Standard"
+          value={props.models.max_output_tokens ?? ""}
+          onChange={(e) => {
+            set({ max_output_tokens: optionalNumber(e.target.value) });
+          }}
+        />
+      </label>
+    </details>
+  );
+}
+
 function Die(props: { face: number; className?: string }) {
   return (
     <div className="die_container">
@@ -3,6 +3,13 @@ import {
   GameState,
   PlayerData,
   type GameData,
+  type Scenario,
+  type SavePoint,
+  type Model,
+  ModelSchema,
+  type ModelSettings,
+  type TreeNode,
+  TreeNodeSchema,
 } from "./types.ts";
 import { Sync } from "./sync.ts";
 import z from "zod";
@@ -21,7 +28,106 @@ if (gameWsUri.protocol === "http:") {
   gameWsUri.protocol = "wss:";
 }
 
-const ws = new WebSocket(gameWsUri.toString());
+const gameEventsUri = new URL(location.href);
+gameEventsUri.pathname = "/api/game_events";
+const gameActionUri = new URL(location.href);
+gameActionUri.pathname = "/api/game_action";
+const transcribeUri = new URL(location.href);
+transcribeUri.pathname = "/api/game/transcribe";
+const savePointsUri = new URL(location.href);
+savePointsUri.pathname = "/api/game/savepoints";
+const forkUri = new URL(location.href);
+forkUri.pathname = "/api/game/fork";
+const treeUri = new URL(location.href);
+treeUri.pathname = "/api/game/tree";
+const modelsUri = new URL(location.href);
+modelsUri.pathname = "/api/models";
+
+// time to wait for the websocket before falling back to Server-Sent Events
+const wsOpenTimeout = 5000;
+// time to wait before a closed websocket is opened again, e.g. after the hub
+// dropped it, the new connection starts with the full state
+const wsReconnectDelay = 2000;
+
+interface Transport {
+  isOpen(): boolean;
+  send(data: object): void;
+}
+
+class WsTransport implements Transport {
+  public constructor(private readonly ws: WebSocket) {}
+
+  public isOpen() {
+    return this.ws.readyState === WebSocket.OPEN;
+  }
+
+  public send(data: object) {
+    this.ws.send(JSON.stringify(data));
+  }
+}
+
+class SseTransport implements Transport {
+  public constructor(private readonly es: EventSource) {}
+
+  public isOpen() {
+    return this.es.readyState === EventSource.OPEN;
+  }
+
+  public send(data: object) {
+    fetch(gameActionUri, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(data),
+    }).then((resp) => {
+      if (!resp.ok) {
+        resp.text().then((text) => error(`action failed: ${text}`));
+      }
+    });
+  }
+}
+
+let transport: Transport | null = null;
+
+function connectWs() {
+  const ws = new WebSocket(gameWsUri.toString());
+  let opened = false;
+  let fellBack = false;
+  const fallback = () => {
+    if (opened || fellBack) {
+      return;
+    }
+    fellBack = true;
+    console.warn("websocket unavailable, falling back to Server-Sent Events");
+    ws.close();
+    connectSse();
+  };
+  const timeout = setTimeout(fallback, wsOpenTimeout);
+  ws.addEventListener("open", () => {
+    opened = true;
+    clearTimeout(timeout);
+  });
+  ws.addEventListener("error", fallback);
+  ws.addEventListener("close", fallback);
+  ws.addEventListener("close", () => {
+    if (opened) {
+      setTimeout(connectWs, wsReconnectDelay);
+    }
+  });
+  ws.addEventListener("message", (ev) => handleMessage(ev.data), {
+    capture: false,
+    passive: true,
+  });
+  transport = new WsTransport(ws);
+}
+
+function connectSse() {
+  const es = new EventSource(gameEventsUri.toString());
+  es.addEventListener("message", (ev) => handleMessage(ev.data), {
+    capture: false,
+    passive: true,
+  });
+  transport = new SseTransport(es);
+}
 
 const gameSync = new Sync<GameData>({
   id: "",
@@ -33,9 +139,21 @@ const gameSync = new Sync<GameData>({
     event_short_history: [],
     chat_history: [],
     entity_data: {},
+    boundaries: { lines: [], veils: [], x_carded: [] },
   },
   roll: null,
   accepting_input: false,
+  settings: {
+    scenario: "",
+    violence_level: 1,
+    duration: 1,
+    tts_backend: "",
+    language: "",
+    models: {},
+    boundaries: { lines: [], veils: [], x_carded: [] },
+  },
+  host: "",
+  save_points: [],
 });
 
 const WsFullOverwrite = z.object({
@@ -53,41 +171,52 @@ const WsPush = z.object({
   value: z.any(),
 });
 
+const WsNotice = z.object({
+  method: z.literal("notice"),
+  message: z.string(),
+});
+
 const WsDataSchema = z.discriminatedUnion("method", [
   WsFullOverwrite,
   WsSet,
   WsPush,
+  WsNotice,
 ]);
 
-ws.addEventListener(
-  "message",
-  (ev) => {
-    console.debug("from ws:", ev.data);
-    const dataObject =
-      typeof ev.data === "object" ? ev.data : JSON.parse(ev.data);
-
-    const resp = WsDataSchema.safeParse(dataObject);
-    if (resp.success) {
-      switch (resp.data.method) {
-        case "full_overwrite":
-          gameSync.override(resp.data.value);
-          break;
-        case "set":
-          gameSync.set(resp.data.path, resp.data.value);
-          break;
-        case "push":
-          gameSync.push(resp.data.path, resp.data.value);
-          break;
-      }
-    } else {
-      throw new Error(
-        "invalid message format received from server:\n" +
-          resp.error.issues.map(zodErr).join("\n\n"),
-      );
+function handleMessage(data: any) {
+  console.debug("from server:", data);
+  const dataObject = typeof data === "object" ? data : JSON.parse(data);
+
+  const resp = WsDataSchema.safeParse(dataObject);
+  if (resp.success) {
+    switch (resp.data.method) {
+      case "full_overwrite":
+        gameSync.override(resp.data.value);
+        break;
+      case "set":
+        gameSync.set(resp.data.path, resp.data.value);
+        break;
+      case "push":
+        gameSync.push(resp.data.path, resp.data.value);
+        break;
+      case "notice":
+        console.info(resp.data.message);
+        alert(resp.data.message);
+        break;
     }
-  },
-  { capture: false, passive: true },
-);
+  } else {
+    throw new Error(
+      "invalid message format received from server:\n" +
+        resp.error.issues.map(zodErr).join("\n\n"),
+    );
+  }
+}
+
+connectWs();
+
+function isOpen() {
+  return transport !== null && transport.isOpen();
+}
 
 export function useGameData() {
   return useSyncExternalStore((onStoreChange) => {
@@ -102,47 +231,67 @@ function error(message: string) {
 }
 
 export function setPlayerCharacterDescription(description: PlayerData) {
-  if (ws.readyState !== WebSocket.OPEN) {
-    error("can't set player character description, WebSocket is not open");
+  if (!isOpen()) {
+    error("can't set player character description, connection is not open");
     return;
   }
 
-  ws.send(
-    JSON.stringify({
-      action: "set_player_character_description",
-      player: description,
-    }),
-  );
+  transport!.send({
+    action: "set_player_character_description",
+    player: description,
+  });
 }
 
+// startGame starts the campaign with the selected built-in scenario or, if
+// customScenario is given, with the scenario written by the players.
 export function startGame(
   selectedScenario: string,
+  customScenario: Scenario | null,
   violenceLevel: number,
   duration: number,
+  ttsBackend: string,
+  language: string,
+  models: ModelSettings,
 ) {
-  if (ws.readyState !== WebSocket.OPEN) {
-    error("can't start game, WebSocket is not open");
+  if (!isOpen()) {
+    error("can't start game, connection is not open");
     return;
   }
 
-  if (!selectedScenario) {
+  if (!selectedScenario && !customScenario) {
     error("can't start game, no scenario selected");
     return;
   }
 
-  ws.send(
-    JSON.stringify({
-      action: "start",
-      scenario: selectedScenario,
-      violence_level: violenceLevel,
-      duration: duration,
-    }),
-  );
+  transport!.send({
+    action: "start",
+    scenario: customScenario ? "" : selectedScenario,
+    custom_scenario: customScenario ?? undefined,
+    violence_level: violenceLevel,
+    duration: duration,
+    tts_backend: ttsBackend,
+    language: language,
+    models: models,
+  });
+}
+
+// setBoundaries replaces the lines and veils of the table, every player can
+// change them in the lobby.
+export function setBoundaries(lines: string[], veils: string[]) {
+  if (!isOpen()) {
+    error("can't set lines and veils, connection is not open");
+    return;
+  }
+
+  transport!.send({
+    action: "set_boundaries",
+    boundaries: { lines, veils },
+  });
 }
 
 export function userInput(input: string) {
-  if (ws.readyState !== WebSocket.OPEN) {
-    error("can't send user input, WebSocket is not open");
+  if (!isOpen()) {
+    error("can't send user input, connection is not open");
     return;
   }
 
@@ -152,23 +301,111 @@ export function userInput(input: string) {
     return;
   }
 
-  ws.send(
-    JSON.stringify({
-      action: "user_input",
-      input,
-    }),
-  );
+  transport!.send({
+    action: "user_input",
+    input,
+  });
+}
+
+// transcribe returns the text spoken in a recording, so the player can check
+// it before sending it with userInput.
+export async function transcribe(recording: Blob): Promise<string | null> {
+  const resp = await fetch(transcribeUri, {
+    method: "POST",
+    headers: { "Content-Type": recording.type },
+    body: recording,
+  });
+  if (!resp.ok) {
+    error(`transcription failed: ${await resp.text()}`);
+    return null;
+  }
+  const data = await resp.json();
+  return typeof data.transcript === "string" ? data.transcript : null;
 }
 
 export function continueAfterRoll() {
-  if (ws.readyState !== WebSocket.OPEN) {
-    error("can't send user input, WebSocket is not open");
+  if (!isOpen()) {
+    error("can't send user input, connection is not open");
+    return;
+  }
+
+  transport!.send({
+    action: "continue_after_roll",
+  });
+}
+
+// xCard rejects the last message of the narrator, it is written again
+// without the content. topic may name what to avoid from now on.
+export function xCard(topic: string) {
+  if (!isOpen()) {
+    error("can't play the X-card, connection is not open");
     return;
   }
 
-  ws.send(
-    JSON.stringify({
-      action: "continue_after_roll",
-    }),
-  );
+  transport!.send({
+    action: "x_card",
+    topic: topic.trim(),
+  });
+}
+
+// createSavePoint stores the current state of the campaign, only the host
+// can do that. The new save point reaches all players with a "set".
+export async function createSavePoint(
+  name: string,
+): Promise<SavePoint | null> {
+  const resp = await fetch(savePointsUri, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ name }),
+  });
+  if (!resp.ok) {
+    error(`creating the save point failed: ${await resp.text()}`);
+    return null;
+  }
+  return await resp.json();
+}
+
+// forkGame starts a new game from a save point and returns its id.
+export async function forkGame(savePointId: string): Promise<string | null> {
+  const uri = new URL(forkUri);
+  uri.searchParams.set("savepoint", savePointId);
+  const resp = await fetch(uri, { method: "POST" });
+  if (!resp.ok) {
+    error(`forking the game failed: ${await resp.text()}`);
+    return null;
+  }
+  const data = await resp.json();
+  return typeof data.id === "string" ? data.id : null;
+}
+
+// fetchTree returns the branch tree of the campaign.
+export async function fetchTree(): Promise<TreeNode | null> {
+  const resp = await fetch(treeUri);
+  if (!resp.ok) {
+    console.error(`loading the branch tree failed: ${await resp.text()}`);
+    return null;
+  }
+  const tree = TreeNodeSchema.safeParse(await resp.json());
+  if (!tree.success) {
+    console.error(tree.error.issues.map(zodErr).join("\n\n"));
+    return null;
+  }
+  return tree.data;
+}
+
+// fetchModels returns the models the server can play a campaign with.
+export async function fetchModels(): Promise<Model[]> {
+  const resp = await fetch(modelsUri);
+  if (!resp.ok) {
+    console.error(`loading the models failed: ${await resp.text()}`);
+    return [];
+  }
+  const models = z
+    .object({ models: z.array(ModelSchema) })
+    .safeParse(await resp.json());
+  if (!models.success) {
+    console.error(models.error.issues.map(zodErr).join("\n\n"));
+    return [];
+  }
+  return models.data.models;
 }
@@ -22,11 +22,20 @@ export function descriptionEquals(a: PlayerData, b: PlayerData) {
   }
   return true;
 }
+export const DiceRollSchema = z.object({
+  difficulty: z.number(),
+  result: z.number(),
+});
+export type DiceRoll = z.infer<typeof DiceRollSchema>;
+
 export const ChatMessageShema = z.discriminatedUnion("role", [
   z.object({
     role: z.literal("model"),
     message: z.string(),
     audio: z.string().nullable(),
+    audio_segments: z.array(z.string()).optional(),
+    image: z.string().optional(),
+    roll: DiceRollSchema.optional(),
   }),
   z.object({
     role: z.literal("user"),
@@ -37,25 +46,120 @@ export const ChatMessageShema = z.discriminatedUnion("role", [
 ]);
 export type ChatMessage = z.infer<typeof ChatMessageShema>;
 
+// BoundariesSchema are the lines and veils of a table, x_carded is only set
+// on the AI by the X-card.
+export const BoundariesSchema = z.object({
+  lines: z.array(z.string()).default([]),
+  veils: z.array(z.string()).default([]),
+  x_carded: z.array(z.string()).default([]),
+});
+export type Boundaries = z.infer<typeof BoundariesSchema>;
+
 export const AIShema = z.object({
   event_plan: z.array(z.string()),
   event_long_history: z.array(z.string()),
   event_short_history: z.array(z.string()),
   chat_history: z.array(ChatMessageShema),
   entity_data: z.record(z.array(z.string())),
+  boundaries: BoundariesSchema.default({}),
 });
 export type AI = z.infer<typeof AIShema>;
 
-export const DiceRollSchema = z.object({
-  difficulty: z.number(),
-  result: z.number(),
-});
-export type DiceRoll = z.infer<typeof DiceRollSchema>;
-
 export const GameState = { LOADING: -1, INIT: 0, RUNNING: 1 } as const;
 export const GameStateShema = z.nativeEnum(GameState);
 export type GameState = z.infer<typeof GameStateShema>;
 
+export const ScenarioSchema = z.object({
+  id: z.string(),
+  title: z.string(),
+  description: z.string(),
+  tone: z.string(),
+  themes: z.array(z.string()).nullable(),
+  npc_types: z.array(z.string()).nullable(),
+  dangers: z.array(z.string()).nullable(),
+  image: z.string().optional(),
+  language: z.string().optional(),
+  dice: z.string().optional(),
+  starting_location: z.string().optional(),
+  content_warnings: z.array(z.string()).optional(),
+  durations: z.array(z.number()).optional(),
+  builtin: z.boolean(),
+});
+export type Scenario = z.infer<typeof ScenarioSchema>;
+
+export const LanguageSchema = z.object({
+  code: z.string(),
+  name: z.string(),
+});
+export type Language = z.infer<typeof LanguageSchema>;
+
+export const ModelSchema = z.object({
+  name: z.string(),
+  display_name: z.string(),
+  input_token_limit: z.number(),
+  output_token_limit: z.number(),
+});
+export type Model = z.infer<typeof ModelSchema>;
+
+// ModelSettingsSchema are the model settings of a game, missing fields use
+// the server config.
+export const ModelSettingsSchema = z.object({
+  model: z.string().optional(),
+  thinking_model: z.string().optional(),
+  temperature: z.number().optional(),
+  thinking_budget: z.number().optional(),
+  max_output_tokens: z.number().optional(),
+});
+export type ModelSettings = z.infer<typeof ModelSettingsSchema>;
+
+export const SettingsSchema = z.object({
+  scenario: z.string(),
+  custom_scenario: ScenarioSchema.optional(),
+  violence_level: z.number(),
+  duration: z.number(),
+  tts_backend: z.string().default(""),
+  language: z.string().default(""),
+  models: ModelSettingsSchema.default({}),
+  boundaries: BoundariesSchema.default({}),
+});
+export type Settings = z.infer<typeof SettingsSchema>;
+
+export const SavePointSchema = z.object({
+  id: z.string(),
+  name: z.string(),
+  created: z.string(),
+  turn: z.number(),
+  chat_length: z.number(),
+});
+export type SavePoint = z.infer<typeof SavePointSchema>;
+
+export const LineageSchema = z.object({
+  parent_id: z.string(),
+  save_point_id: z.string(),
+  save_point_name: z.string(),
+});
+export type Lineage = z.infer<typeof LineageSchema>;
+
+// TreeNode is a game in the branch tree of a campaign.
+export interface TreeNode {
+  id: string;
+  state: GameState;
+  turn: number;
+  chat_length: number;
+  lineage?: Lineage;
+  children: TreeNode[];
+}
+export const TreeNodeSchema: z.ZodType<TreeNode> = z.lazy(() =>
+  z.object({
+    id: z.string(),
+    state: GameStateShema,
+    turn: z.number(),
+    chat_length: z.number(),
+    lineage: LineageSchema.optional(),
+    children: z.array(TreeNodeSchema),
+  }),
+);
+
 export const GameDataShema = z.object({
   id: z.string(),
   players: z.record(PlayerShema),
@@ -63,6 +167,14 @@ export const GameDataShema = z.object({
   ai: AIShema,
   roll: DiceRollSchema.nullable(),
   accepting_input: z.boolean(),
+  settings: SettingsSchema,
+  host: z.string().default(""),
+  // save_points is null until the first save point is created
+  save_points: z
+    .array(SavePointSchema)
+    .nullish()
+    .transform((v) => v ?? []),
+  lineage: LineageSchema.optional(),
 });
 export type GameData = z.infer<typeof GameDataShema>;
 
@@ -2,7 +2,7 @@ package pages
 
 import (
 	"gameslabor/internal/server/context"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/a-h/templ"
@@ -13,14 +13,14 @@ var pageRegister = map[string]func() templ.Component{}
 func Handler(w http.ResponseWriter, r *http.Request) {
 	if h, ok := pageRegister[r.URL.Path]; ok {
 		if err := h().Render(context.From(w, r), w); err != nil {
-			log.Println("Error in pages handler", err.Error())
+			slog.ErrorContext(r.Context(), "error in pages handler", "path", r.URL.Path, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
 	if h, ok := pageRegister[r.URL.Path+"/"]; ok {
 		if err := h().Render(context.From(w, r), w); err != nil {
-			log.Println("Error in pages handler", err.Error())
+			slog.ErrorContext(r.Context(), "error in pages handler", "path", r.URL.Path, "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
@@ -108,6 +108,15 @@ func Path(name string) string {
 	return normalPathToServedPath[name]
 }
 
+// URL returns the served path of name if it is a public file.
+// Anything else, e.g. an external URL, is returned unchanged.
+func URL(name string) string {
+	if served, ok := normalPathToServedPath[name]; ok {
+		return served
+	}
+	return name
+}
+
 func Integrity(name string) string {
 	if integrity, ok := normalPathToIntegrity[name]; ok {
 		return integrity
@@ -1,63 +1,74 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gameslabor/internal/ai"
-	"gameslabor/internal/env"
+	"gameslabor/internal/config"
+	"gameslabor/internal/games"
+	"gameslabor/internal/metrics"
 	"gameslabor/internal/server/api"
+	"gameslabor/internal/server/hub"
 	"gameslabor/internal/server/pages"
 	"gameslabor/internal/server/public"
+	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
 )
 
 type Server struct {
-	ai         *ai.AI
+	cfg        *config.Config
 	mux        *http.ServeMux
 	httpServer *http.Server
 }
 
-func NewServer() *Server {
+// NewServer configures the packages it serves with cfg, so it has to be
+// called before games are loaded.
+func NewServer(cfg *config.Config) *Server {
+	ai.SetAssetStore(ai.NewDirStore(cfg.AssetDir, cfg.AssetQuota()))
+	games.SetConfig(cfg)
+	api.SetConfig(cfg)
+
 	mux := http.DefaultServeMux
 	mux.HandleFunc("/public/", public.Handler)
 	mux.HandleFunc("/ai/", ai.Handler)
 	mux.HandleFunc("/api/", api.Handler)
+	mux.Handle("/metrics", metrics.Handler())
 	mux.HandleFunc("/", pages.Handler)
 	return &Server{
+		cfg: cfg,
 		mux: mux,
+		httpServer: &http.Server{
+			Addr:    ":" + strconv.Itoa(cfg.Port),
+			Handler: mux,
+		},
 	}
 }
 
 func (s *Server) Start() error {
-	addr := ":" + strconv.Itoa(env.PORT)
-	listener, err := net.Listen("tcp", addr)
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
 	if err != nil {
 		return err
 	}
-	server := &http.Server{
-		Addr:    addr,
-		Handler: s.mux,
-	}
 
 	if ip, err := getLocalIP(); err == nil {
-		fmt.Printf("http://%s:%d\n", ip, env.PORT)
+		slog.Info("listening", "url", fmt.Sprintf("http://%s:%d", ip, s.cfg.Port))
 	}
-	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+	if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 		return err
 	}
 	return nil
 }
 
-func (s *Server) Close() {
-	if s.httpServer != nil {
-		_ = s.httpServer.Close()
-		s.httpServer = nil
-	}
-	if s.ai != nil {
-		s.ai.Close()
-		s.ai = nil
-	}
+// Shutdown lets the running turns finish, saves all games and closes every
+// connection. ctx limits how long to wait for running turns and open requests.
+func (s *Server) Shutdown(ctx context.Context) error {
+	gamesErr := games.Shutdown(ctx, s.cfg.DataDir)
+	// closes websocket and SSE subscribers so their handlers return
+	hub.StopHub()
+	return errors.Join(gamesErr, s.httpServer.Shutdown(ctx))
 }
 
 // getLocalIP retrieves the local IP address of the computer.